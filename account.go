@@ -18,6 +18,7 @@ import (
 	"context"
 	"encoding/json"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -25,6 +26,13 @@ import (
 	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
 )
 
+// errNotAccount is returned when deserializing data that is held alongside
+// accounts in the store but is not itself an account, such as a tombstone.
+var errNotAccount = errors.New("not an account")
+
+// tombstoneRecord is the record type of a deleted account.
+const tombstoneRecord = "tombstone"
+
 // account contains the details of the account.
 type account struct {
 	id        uuid.UUID
@@ -37,6 +45,15 @@ type account struct {
 	wallet    *wallet
 	encryptor e2wtypes.Encryptor
 	mutex     sync.Mutex
+	// unlockedAt is the time at which the account was unlocked.
+	unlockedAt time.Time
+	// lastUsed is the time at which the account last signed.
+	lastUsed time.Time
+	// lockTimer locks the account once a timeout passes.
+	lockTimer Timer
+	// lockGeneration identifies the current lock timer, so that a timer
+	// that fires after being replaced does nothing.
+	lockGeneration uint64
 }
 
 // newAccount creates a new account.
@@ -60,12 +77,28 @@ func (a *account) PublicKey() e2types.PublicKey {
 }
 
 // PrivateKey provides the private key for the account.
+// The key is a copy, so it is not cleared when the account is locked.
 func (a *account) PrivateKey(_ context.Context) (e2types.PrivateKey, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.expireIfRequired()
 	if !a.unlocked {
-		return nil, errors.New("cannot provide private key when account is locked")
+		return nil, newError(ErrAccountLocked, "cannot provide private key when account is locked")
+	}
+
+	if a.secretKey == nil {
+		return nil, errors.New("missing private key for unlocked account")
 	}
 
-	return a.secretKey, nil
+	keyBytes := a.secretKey.Marshal()
+	defer zeroBytes(keyBytes)
+	key, err := e2types.BLSPrivateKeyFromBytes(keyBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to copy private key")
+	}
+
+	return key, nil
 }
 
 // Wallet provides the wallet for the account.
@@ -74,20 +107,111 @@ func (a *account) Wallet() e2wtypes.Wallet {
 }
 
 // Lock locks the account.  A locked account cannot sign data.
+// Locking clears the private key from memory, so it is decrypted again
+// the next time that the account is unlocked.
 func (a *account) Lock(_ context.Context) error {
 	a.mutex.Lock()
-	a.unlocked = false
+	a.lock()
 	a.mutex.Unlock()
 
 	return nil
 }
 
+// lock locks the account and clears its private key.
+// The account mutex must be held.
+func (a *account) lock() {
+	a.unlocked = false
+	a.stopLockTimer()
+	if a.secretKey != nil {
+		zeroizeKey(a.secretKey)
+		a.secretKey = nil
+	}
+
+	if a.crypto == nil && a.wallet != nil {
+		// This is a batch account, so the batch will need to be decrypted again.
+		a.wallet.batchMutex.Lock()
+		a.wallet.clearBatchKeys()
+		a.wallet.batchMutex.Unlock()
+	}
+}
+
+// expireIfRequired locks the account if it has been unlocked for longer than
+// the wallet's unlock timeout, or has not signed for longer than the wallet's
+// idle timeout.
+// The account mutex must be held.
+func (a *account) expireIfRequired() {
+	if !a.unlocked || a.wallet == nil {
+		return
+	}
+
+	now := a.wallet.clock()
+	if a.wallet.unlockTimeout > 0 && now.Sub(a.unlockedAt) >= a.wallet.unlockTimeout {
+		a.lock()
+		return
+	}
+	if a.wallet.idleTimeout > 0 && now.Sub(a.lastUsed) >= a.wallet.idleTimeout {
+		a.lock()
+	}
+}
+
+// scheduleLock schedules the account to be locked when the first of the
+// wallet's unlock and idle timeouts passes, so that its key does not remain
+// in memory if it is not used again.  Signing does not reschedule the lock,
+// so when the timer fires for an idle timeout that has since been extended
+// it schedules itself again.
+// The account mutex must be held.
+func (a *account) scheduleLock() {
+	a.stopLockTimer()
+	if !a.unlocked || a.wallet == nil {
+		return
+	}
+
+	var deadline time.Time
+	if a.wallet.unlockTimeout > 0 {
+		deadline = a.unlockedAt.Add(a.wallet.unlockTimeout)
+	}
+	if a.wallet.idleTimeout > 0 {
+		idleDeadline := a.lastUsed.Add(a.wallet.idleTimeout)
+		if deadline.IsZero() || idleDeadline.Before(deadline) {
+			deadline = idleDeadline
+		}
+	}
+	if deadline.IsZero() {
+		return
+	}
+
+	generation := a.lockGeneration
+	a.lockTimer = a.wallet.afterFunc(deadline.Sub(a.wallet.clock()), func() {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+
+		if a.lockGeneration != generation {
+			// Timer has been replaced.
+			return
+		}
+		a.lockTimer = nil
+		a.expireIfRequired()
+		a.scheduleLock()
+	})
+}
+
+// stopLockTimer stops any timer that would lock the account.
+// The account mutex must be held.
+func (a *account) stopLockTimer() {
+	a.lockGeneration++
+	if a.lockTimer != nil {
+		a.lockTimer.Stop()
+		a.lockTimer = nil
+	}
+}
+
 // Unlock unlocks the account.  An unlocked account can sign data.
 func (a *account) Unlock(ctx context.Context, passphrase []byte) error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 
 	// If the account is already unlocked then nothing to do.
+	a.expireIfRequired()
 	if a.unlocked {
 		return nil
 	}
@@ -95,17 +219,26 @@ func (a *account) Unlock(ctx context.Context, passphrase []byte) error {
 	if a.secretKey == nil {
 		// First time unlocking, need to decrypt.
 		if a.crypto == nil {
-			// This is a batch account, decrypt the batch.
-			if err := a.wallet.batchDecrypt(ctx, passphrase); err != nil {
-				return errors.Wrap(err, "failed to decrypt batch")
+			// This is a batch account, decrypt the batch.  If that fails, the
+			// passphrase may be for the individual account in the store.
+			privateKey, err := a.wallet.batchDecrypt(ctx, passphrase, a.id)
+			if err == nil {
+				a.secretKey = privateKey
+			} else {
+				privateKey, storeErr := a.decryptStoredPrivateKey(passphrase)
+				if storeErr != nil {
+					return errors.Wrap(err, "failed to decrypt batch")
+				}
+				a.secretKey = privateKey
 			}
 		} else {
 			// This is an individual account, decrypt the account.
 			privateKeyBytes, err := a.encryptor.Decrypt(a.crypto, string(passphrase))
 			if err != nil {
-				return errors.New("incorrect passphrase")
+				return ErrIncorrectPassphrase
 			}
 			privateKey, err := e2types.BLSPrivateKeyFromBytes(privateKeyBytes)
+			zeroBytes(privateKeyBytes)
 			if err != nil {
 				return errors.Wrap(err, "failed to obtain private key")
 			}
@@ -115,18 +248,106 @@ func (a *account) Unlock(ctx context.Context, passphrase []byte) error {
 		// Ensure the private key is correct.
 		publicKey := a.secretKey.PublicKey()
 		if !bytes.Equal(publicKey.Marshal(), a.publicKey.Marshal()) {
+			zeroizeKey(a.secretKey)
 			a.secretKey = nil
 			return errors.New("private key does not correspond to public key")
 		}
 	}
 
 	a.unlocked = true
+	if a.wallet != nil {
+		a.unlockedAt = a.wallet.clock()
+		a.lastUsed = a.unlockedAt
+	}
+	if err := a.wallet.audit(ctx, AuditUnlock, a, nil); err != nil {
+		a.lock()
+		return err
+	}
+	a.scheduleLock()
+
+	return nil
+}
+
+// ChangePassphrase changes the passphrase that protects the account's private key.
+// The account keeps its ID, name and public key.
+func (a *account) ChangePassphrase(ctx context.Context, oldPassphrase []byte, newPassphrase []byte) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	target := a
+	if a.crypto == nil {
+		// This is a batch account, so work from the stored account.
+		data, err := a.wallet.store.RetrieveAccount(a.wallet.ID(), a.id)
+		if err != nil {
+			return errors.Wrap(err, "failed to retrieve account")
+		}
+		target, err = deserializeAccount(a.wallet, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	privateKey, err := target.decryptPrivateKey(oldPassphrase)
+	if err != nil {
+		return err
+	}
+	defer zeroizeKey(privateKey)
+
+	crypto, err := target.encryptor.Encrypt(privateKey.Marshal(), string(newPassphrase))
+	if err != nil {
+		return errors.Wrap(err, "failed to encrypt private key")
+	}
+	oldCrypto := target.crypto
+	target.crypto = crypto
+	if err := target.storeAccount(ctx); err != nil {
+		target.crypto = oldCrypto
+		return err
+	}
 
 	return nil
 }
 
+// decryptStoredPrivateKey decrypts the private key of a batch account from
+// the individual account in the store.
+func (a *account) decryptStoredPrivateKey(passphrase []byte) (e2types.PrivateKey, error) {
+	data, err := a.wallet.store.RetrieveAccount(a.wallet.ID(), a.id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve account")
+	}
+	stored, err := deserializeAccount(a.wallet, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return stored.decryptPrivateKey(passphrase)
+}
+
+// decryptPrivateKey decrypts the account's private key with the given passphrase,
+// and ensures that it corresponds to the account's public key.
+func (a *account) decryptPrivateKey(passphrase []byte) (e2types.PrivateKey, error) {
+	privateKeyBytes, err := a.encryptor.Decrypt(a.crypto, string(passphrase))
+	if err != nil {
+		return nil, ErrIncorrectPassphrase
+	}
+	privateKey, err := e2types.BLSPrivateKeyFromBytes(privateKeyBytes)
+	zeroBytes(privateKeyBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to obtain private key")
+	}
+	if !bytes.Equal(privateKey.PublicKey().Marshal(), a.publicKey.Marshal()) {
+		return nil, errors.New("private key does not correspond to public key")
+	}
+
+	return privateKey, nil
+}
+
 // IsUnlocked returns true if the account is unlocked.
 func (a *account) IsUnlocked(_ context.Context) (bool, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.expireIfRequired()
+
 	return a.unlocked, nil
 }
 
@@ -137,23 +358,44 @@ func (a *account) Path() string {
 
 // Sign signs data.
 func (a *account) Sign(ctx context.Context, data []byte) (e2types.Signature, error) {
-	a.mutex.Lock()
-	unlocked, err := a.IsUnlocked(ctx)
-	if err != nil {
-		a.mutex.Unlock()
+	var signature e2types.Signature
+	if err := a.withSigningKey(func(key e2types.PrivateKey) error {
+		signature = key.Sign(data)
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := a.wallet.audit(ctx, AuditSign, a, data); err != nil {
 		return nil, err
 	}
-	if !unlocked {
-		a.mutex.Unlock()
-		return nil, errors.New("cannot sign when account is locked")
+
+	return signature, nil
+}
+
+// withSigningKey calls the supplied function with the account's private key
+// if the account is unlocked.  The lock is held throughout, as locking clears
+// the private key.
+func (a *account) withSigningKey(fn func(key e2types.PrivateKey) error) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.expireIfRequired()
+	if !a.unlocked {
+		return newError(ErrAccountLocked, "cannot sign when account is locked")
 	}
 	if a.secretKey == nil {
-		a.mutex.Unlock()
-		return nil, errors.New("missing private key for unlocked account")
+		return errors.New("missing private key for unlocked account")
 	}
-	a.mutex.Unlock()
 
-	return a.secretKey.Sign(data), nil
+	if err := fn(a.secretKey); err != nil {
+		return err
+	}
+	if a.wallet != nil {
+		a.lastUsed = a.wallet.clock()
+	}
+
+	return nil
 }
 
 // storeAccount stores the account.
@@ -180,6 +422,25 @@ func (a *account) storeAccount(ctx context.Context) error {
 	return nil
 }
 
+// storeTombstone replaces the stored data for an account with a tombstone.
+func (w *wallet) storeTombstone(id uuid.UUID) error {
+	data, err := json.Marshal(map[string]any{
+		"uuid":   id.String(),
+		"record": tombstoneRecord,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal tombstone")
+	}
+	if err := w.storeAccountsIndex(); err != nil {
+		return errors.Wrap(err, "failed to store account index")
+	}
+	if err := w.store.StoreAccount(w.ID(), id, data); err != nil {
+		return errors.Wrap(err, "failed to store tombstone")
+	}
+
+	return nil
+}
+
 // deserializeAccount deserializes account data to an account.
 func deserializeAccount(w *wallet, data []byte) (*account, error) {
 	a := newAccount()
@@ -191,3 +452,18 @@ func deserializeAccount(w *wallet, data []byte) (*account, error) {
 
 	return a, nil
 }
+
+// zeroizeKey overwrites the material of a private key in memory.
+// Any other references to the key will also see it cleared.
+func zeroizeKey(key e2types.PrivateKey) {
+	if blsKey, isBLSKey := key.(*e2types.BLSPrivateKey); isBLSKey {
+		*blsKey = e2types.BLSPrivateKey{}
+	}
+}
+
+// zeroBytes overwrites a byte slice with zeros.
+func zeroBytes(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
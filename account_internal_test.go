@@ -17,13 +17,18 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	e2types "github.com/wealdtech/go-eth2-types/v2"
+	keystorev4 "github.com/wealdtech/go-eth2-wallet-encryptor-keystorev4"
+	scratch "github.com/wealdtech/go-eth2-wallet-store-scratch"
+	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
 )
 
 func TestMain(m *testing.M) {
@@ -244,3 +249,298 @@ func TestUnlock(t *testing.T) {
 		})
 	}
 }
+
+func TestChangePassphrase(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	created, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account", []byte("old"))
+	require.NoError(t, err)
+	a := created.(*account)
+
+	require.EqualError(t, a.ChangePassphrase(ctx, []byte("wrong"), []byte("new")), "incorrect passphrase")
+	require.NoError(t, a.ChangePassphrase(ctx, []byte("old"), []byte("new")))
+
+	// Re-open the wallet and confirm the change.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	obtained, err := w.(e2wtypes.WalletAccountByNameProvider).AccountByName(ctx, "account")
+	require.NoError(t, err)
+	require.Equal(t, a.ID(), obtained.ID())
+	require.EqualError(t, obtained.(e2wtypes.AccountLocker).Unlock(ctx, []byte("old")), "incorrect passphrase")
+	require.NoError(t, obtained.(e2wtypes.AccountLocker).Unlock(ctx, []byte("new")))
+}
+
+func TestChangePassphraseBatch(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	created, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account", []byte("old"))
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletBatchCreator).BatchWallet(ctx, []string{"old"}, "batch passphrase"))
+
+	// Re-open the wallet to obtain the account from the batch.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	obtained, err := w.(e2wtypes.WalletAccountByIDProvider).AccountByID(ctx, created.ID())
+	require.NoError(t, err)
+	require.Nil(t, obtained.(*account).crypto)
+	require.NoError(t, obtained.(*account).ChangePassphrase(ctx, []byte("old"), []byte("new")))
+
+	// The individual keystore should use the new passphrase.
+	data, err := store.RetrieveAccount(w.ID(), created.ID())
+	require.NoError(t, err)
+	stored, err := deserializeAccount(w.(*wallet), data)
+	require.NoError(t, err)
+	require.EqualError(t, stored.Unlock(ctx, []byte("old")), "incorrect passphrase")
+	require.NoError(t, stored.Unlock(ctx, []byte("new")))
+
+	// The batch should be unaffected.
+	require.NoError(t, obtained.(e2wtypes.AccountLocker).Unlock(ctx, []byte("batch passphrase")))
+}
+
+func TestLockClearsKey(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	created, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account", []byte("test"))
+	require.NoError(t, err)
+	a := created.(*account)
+	require.NoError(t, a.Unlock(ctx, []byte("test")))
+	key, err := a.PrivateKey(ctx)
+	require.NoError(t, err)
+	require.NotEqual(t, make([]byte, 32), key.Marshal())
+
+	secretKey := a.secretKey
+
+	require.NoError(t, a.Lock(ctx))
+	require.Nil(t, a.secretKey)
+	require.Equal(t, make([]byte, 32), secretKey.Marshal())
+
+	// The key provided to the caller is a copy, so is unaffected.
+	require.NotEqual(t, make([]byte, 32), key.Marshal())
+	require.True(t, key.Sign([]byte("test")).Verify([]byte("test"), a.PublicKey()))
+
+	// Unlocking again should decrypt the key again.
+	require.NoError(t, a.Unlock(ctx, []byte("test")))
+	signature, err := a.Sign(ctx, []byte("test"))
+	require.NoError(t, err)
+	require.True(t, signature.Verify([]byte("test"), a.PublicKey()))
+}
+
+func TestLockAll(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	for _, name := range []string{"account1", "account2"} {
+		_, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, name, []byte("test"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.(e2wtypes.WalletBatchCreator).BatchWallet(ctx, []string{"test"}, "batch passphrase"))
+
+	// Re-open the wallet to use the batch.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	accounts := make([]*account, 0)
+	for a := range w.Accounts(ctx) {
+		require.NoError(t, a.(e2wtypes.AccountLocker).Unlock(ctx, []byte("batch passphrase")))
+		accounts = append(accounts, a.(*account))
+	}
+	require.Len(t, accounts, 2)
+	require.True(t, w.(*wallet).batchDecrypted)
+
+	// Locking a single batch account should require the batch to be decrypted again.
+	require.NoError(t, accounts[0].Lock(ctx))
+	require.Nil(t, accounts[0].secretKey)
+	require.NotNil(t, accounts[1].secretKey)
+	require.False(t, w.(*wallet).batchDecrypted)
+	require.NoError(t, accounts[0].Unlock(ctx, []byte("batch passphrase")))
+	require.NotNil(t, accounts[0].secretKey)
+
+	require.NoError(t, w.(*wallet).LockAll(ctx))
+	require.False(t, w.(*wallet).batchDecrypted)
+	for _, a := range accounts {
+		unlocked, err := a.IsUnlocked(ctx)
+		require.NoError(t, err)
+		require.False(t, unlocked)
+		require.Nil(t, a.secretKey)
+		_, err = a.Sign(ctx, []byte("test"))
+		require.EqualError(t, err, "cannot sign when account is locked")
+	}
+
+	// Accounts can be unlocked again afterwards.
+	for _, a := range accounts {
+		require.NoError(t, a.Unlock(ctx, []byte("batch passphrase")))
+		_, err := a.Sign(ctx, []byte("test"))
+		require.NoError(t, err)
+	}
+}
+
+func TestBatchUnlockSingleAccount(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	for _, name := range []string{"account1", "account2"} {
+		_, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, name, []byte("test"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.(e2wtypes.WalletBatchCreator).BatchWallet(ctx, []string{"test"}, "batch passphrase"))
+
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	account1, err := w.(e2wtypes.WalletAccountByNameProvider).AccountByName(ctx, "account1")
+	require.NoError(t, err)
+	account2, err := w.(e2wtypes.WalletAccountByNameProvider).AccountByName(ctx, "account2")
+	require.NoError(t, err)
+
+	// Unlocking an account from the batch does not provide keys to others.
+	require.NoError(t, account1.(*account).Unlock(ctx, []byte("batch passphrase")))
+	require.Nil(t, account2.(*account).secretKey)
+
+	// Other accounts still require a valid passphrase.
+	require.ErrorIs(t, account2.(*account).Unlock(ctx, []byte("wrong")), ErrIncorrectPassphrase)
+	require.NoError(t, account2.(*account).Unlock(ctx, []byte("batch passphrase")))
+
+	// Locking clears the keys held from the batch.
+	require.NoError(t, account1.(*account).Lock(ctx))
+	require.Nil(t, w.(*wallet).batchKeys)
+	require.NotNil(t, account2.(*account).secretKey)
+}
+
+// fakeTime provides a clock and timers that only advance when told to.
+type fakeTime struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	time    *fakeTime
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.time.mutex.Lock()
+	defer t.time.mutex.Unlock()
+
+	stopped := t.stopped
+	t.stopped = true
+
+	return !stopped
+}
+
+func (ft *fakeTime) clock() time.Time {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+
+	return ft.now
+}
+
+func (ft *fakeTime) afterFunc(d time.Duration, f func()) Timer {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+
+	timer := &fakeTimer{time: ft, at: ft.now.Add(d), f: f}
+	ft.timers = append(ft.timers, timer)
+
+	return timer
+}
+
+// advance advances the time, calling the functions of any timers that expire.
+func (ft *fakeTime) advance(d time.Duration) {
+	ft.mutex.Lock()
+	ft.now = ft.now.Add(d)
+	expired := make([]func(), 0)
+	for _, timer := range ft.timers {
+		if !timer.stopped && !timer.at.After(ft.now) {
+			timer.stopped = true
+			expired = append(expired, timer.f)
+		}
+	}
+	ft.mutex.Unlock()
+
+	for _, f := range expired {
+		f()
+	}
+}
+
+func TestAccountTimeoutTimer(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		unlockTimeout time.Duration
+		idleTimeout   time.Duration
+	}{
+		{
+			name:          "Unlock",
+			unlockTimeout: time.Minute,
+		},
+		{
+			name:        "Idle",
+			idleTimeout: time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ft := &fakeTime{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+			w, err := CreateWallet(ctx, "test wallet", scratch.New(), keystorev4.New(),
+				WithClock(ft.clock),
+				WithAfterFunc(ft.afterFunc),
+				WithUnlockTimeout(test.unlockTimeout),
+				WithIdleTimeout(test.idleTimeout),
+			)
+			require.NoError(t, err)
+			require.NoError(t, w.(*wallet).Unlock(ctx, nil))
+			created, err := w.(*wallet).CreateAccount(ctx, "account", []byte("test"))
+			require.NoError(t, err)
+			a := created.(*account)
+			require.NoError(t, a.Unlock(ctx, []byte("test")))
+
+			hasKey := func() bool {
+				a.mutex.Lock()
+				defer a.mutex.Unlock()
+
+				return a.secretKey != nil
+			}
+
+			// Signing extends the idle timeout, but not the unlock timeout.
+			ft.advance(30 * time.Second)
+			_, err = a.Sign(ctx, []byte("test"))
+			require.NoError(t, err)
+			ft.advance(30 * time.Second)
+			if test.idleTimeout > 0 {
+				require.True(t, hasKey())
+				ft.advance(30 * time.Second)
+			}
+
+			// The key is cleared without any further calls on the account.
+			require.False(t, hasKey())
+			_, err = a.Sign(ctx, []byte("test"))
+			require.ErrorIs(t, err, ErrAccountLocked)
+		})
+	}
+}
@@ -45,6 +45,10 @@ func (a *account) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &v); err != nil {
 		return errors.Wrap(err, "failed to unmarshal account")
 	}
+	if _, exists := v["record"]; exists {
+		// This is another type of record held alongside accounts.
+		return errNotAccount
+	}
 	if val, exists := v["uuid"]; exists {
 		idStr, ok := val.(string)
 		if !ok {
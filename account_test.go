@@ -20,6 +20,7 @@ import (
 	"math/rand"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -63,6 +64,7 @@ func TestAccountInterfaces(t *testing.T) {
 	require.NotEmpty(t, account.(e2wtypes.AccountWalletProvider).Wallet())
 	require.Implements(t, (*e2wtypes.AccountLocker)(nil), account)
 	require.Implements(t, (*e2wtypes.AccountSigner)(nil), account)
+	require.Implements(t, (*e2wtypes.AccountProtectingSigner)(nil), account)
 	require.Implements(t, (*e2wtypes.AccountPrivateKeyProvider)(nil), account)
 }
 
@@ -271,3 +273,137 @@ func TestConcurrentCreate(t *testing.T) {
 	}
 	require.Equal(t, numAccounts, accounts)
 }
+
+func TestAccountTimeouts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	tests := []struct {
+		name          string
+		unlockTimeout time.Duration
+		idleTimeout   time.Duration
+		// steps are the time advances between signatures.
+		steps []time.Duration
+		// locked is the step after which the account should be locked, or -1 for never.
+		locked int
+	}{
+		{
+			name:   "None",
+			steps:  []time.Duration{time.Hour, 24 * time.Hour},
+			locked: -1,
+		},
+		{
+			name:          "Unlock",
+			unlockTimeout: time.Minute,
+			steps:         []time.Duration{20 * time.Second, 20 * time.Second, 20 * time.Second},
+			locked:        2,
+		},
+		{
+			name:        "IdleActive",
+			idleTimeout: time.Minute,
+			steps:       []time.Duration{50 * time.Second, 50 * time.Second, 50 * time.Second},
+			locked:      -1,
+		},
+		{
+			name:        "IdleExpired",
+			idleTimeout: time.Minute,
+			steps:       []time.Duration{50 * time.Second, 70 * time.Second},
+			locked:      1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := scratch.New()
+			encryptor := keystorev4.New()
+			wallet, err := nd.CreateWallet(ctx, "test wallet", store, encryptor,
+				nd.WithClock(clock),
+				nd.WithUnlockTimeout(test.unlockTimeout),
+				nd.WithIdleTimeout(test.idleTimeout),
+			)
+			require.NoError(t, err)
+			require.NoError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+			account, err := wallet.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account", []byte("test"))
+			require.NoError(t, err)
+			require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("test")))
+
+			for i, step := range test.steps {
+				now = now.Add(step)
+				_, err := account.(e2wtypes.AccountSigner).Sign(ctx, []byte("test"))
+				if i == test.locked {
+					require.ErrorIs(t, err, nd.ErrAccountLocked)
+					unlocked, err := account.(e2wtypes.AccountLocker).IsUnlocked(ctx)
+					require.NoError(t, err)
+					require.False(t, unlocked)
+					_, err = account.(e2wtypes.AccountPrivateKeyProvider).PrivateKey(ctx)
+					require.ErrorIs(t, err, nd.ErrAccountLocked)
+
+					// Should be able to unlock again.
+					require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("test")))
+					_, err = account.(e2wtypes.AccountSigner).Sign(ctx, []byte("test"))
+					require.NoError(t, err)
+
+					break
+				}
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAccountTimeoutOpenWallet(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	wallet, err := nd.CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+	_, err = wallet.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account", []byte("test"))
+	require.NoError(t, err)
+
+	wallet, err = nd.OpenWallet(ctx, "test wallet", store, encryptor, nd.WithClock(clock), nd.WithUnlockTimeout(time.Minute))
+	require.NoError(t, err)
+	account, err := wallet.(e2wtypes.WalletAccountByNameProvider).AccountByName(ctx, "account")
+	require.NoError(t, err)
+	require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("test")))
+	now = now.Add(time.Minute)
+	_, err = account.(e2wtypes.AccountSigner).Sign(ctx, []byte("test"))
+	require.ErrorIs(t, err, nd.ErrAccountLocked)
+	require.EqualError(t, err, "cannot sign when account is locked")
+}
+
+func TestImportAccountDuplicateKey(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	key := _byteArray("220091d10843519cd1c452a4ec721d378d7d4c5ece81c4b5556092d410e5e0e1")
+	_, err := nd.CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+
+	// Duplicate keys are rejected by default.
+	wallet, err := nd.OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+	_, err = wallet.(e2wtypes.WalletAccountImporter).ImportAccount(ctx, "account1", key, []byte("test"))
+	require.NoError(t, err)
+	_, err = wallet.(e2wtypes.WalletAccountImporter).ImportAccount(ctx, "account2", key, []byte("test"))
+	require.ErrorIs(t, err, nd.ErrDuplicateKey)
+
+	// Also rejected when the existing account is only on the store.
+	wallet, err = nd.OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+	_, err = wallet.(e2wtypes.WalletAccountImporter).ImportAccount(ctx, "account2", key, []byte("test"))
+	require.ErrorIs(t, err, nd.ErrDuplicateKey)
+
+	// Allowed when explicitly requested.
+	wallet, err = nd.OpenWallet(ctx, "test wallet", store, encryptor, nd.WithAllowDuplicateKeys())
+	require.NoError(t, err)
+	require.NoError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+	_, err = wallet.(e2wtypes.WalletAccountImporter).ImportAccount(ctx, "account2", key, []byte("test"))
+	require.NoError(t, err)
+}
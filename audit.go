@@ -0,0 +1,314 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+const (
+	auditLogRecordType   = "audit-log"
+	auditEntryRecordType = "audit-entry"
+	auditHeadRecordType  = "audit-head"
+)
+
+// AuditOperation is an operation recorded in the audit log.
+type AuditOperation string
+
+const (
+	// AuditUnlock is recorded when an account is unlocked.
+	AuditUnlock AuditOperation = "unlock"
+	// AuditSign is recorded for each piece of data signed by an account.
+	AuditSign AuditOperation = "sign"
+	// AuditCreateAccount is recorded when an account is created.
+	AuditCreateAccount AuditOperation = "create account"
+	// AuditImportAccount is recorded when an account is imported.
+	AuditImportAccount AuditOperation = "import account"
+)
+
+// AuditEntry is an entry in the audit log.
+type AuditEntry struct {
+	// Sequence is the position of the entry in the log, starting at 1.
+	// It is set by the sink.
+	Sequence  uint64
+	Timestamp time.Time
+	Operation AuditOperation
+	WalletID  uuid.UUID
+	AccountID uuid.UUID
+	PublicKey []byte
+	// Digest is the SHA-256 digest of the signed data, for AuditSign entries.
+	Digest []byte
+	// PrevHash and Hash chain the entries together.  They are set by the sink.
+	PrevHash []byte
+	Hash     []byte
+}
+
+// AuditSink records audit entries.
+// If recording fails the audited operation returns an error; in the case of
+// signing, no signature is returned.
+type AuditSink interface {
+	// Record records an entry.
+	Record(ctx context.Context, entry *AuditEntry) error
+}
+
+// storeAuditSink is an AuditSink that keeps a hash-chained log of entries
+// for each wallet in a store.
+type storeAuditSink struct {
+	store e2wtypes.Store
+	mutex sync.Mutex
+	// logs holds the IDs of wallets whose logs are known to be in the store.
+	logs map[uuid.UUID]bool
+}
+
+// NewStoreAuditSink creates an audit sink that keeps a hash-chained,
+// append-only log of entries for each wallet in the given store.  The log
+// can be checked with VerifyAuditLog.
+//
+// The store must be dedicated to audit logs: each entry is a separate
+// record, so a log grows by one record for each operation (around 225 a day
+// for a validator signing every block and attestation) and would otherwise
+// slow down every operation that reads the wallet's accounts.  Recording
+// fails if the store holds the wallet itself.
+func NewStoreAuditSink(store e2wtypes.Store) AuditSink {
+	return &storeAuditSink{
+		store: store,
+		logs:  make(map[uuid.UUID]bool),
+	}
+}
+
+// Record records an entry.
+func (s *storeAuditSink) Record(_ context.Context, entry *AuditEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.ensureLog(entry.WalletID); err != nil {
+		return err
+	}
+	head, err := retrieveAuditHead(s.store, entry.WalletID)
+	if err != nil {
+		return err
+	}
+	if head.sequence == 0 {
+		// Ensure that a missing head does not result in the log being overwritten.
+		if _, err := s.store.RetrieveAccount(entry.WalletID, auditEntryID(entry.WalletID, 1)); err == nil {
+			return newError(ErrAuditLogTampered, "audit head missing")
+		}
+	}
+
+	entry.Sequence = head.sequence + 1
+	entry.PrevHash = head.hash
+	entry.Hash = entry.calculateHash()
+
+	// Store the entry before the head, so that the head never refers to a
+	// missing entry.
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal audit entry")
+	}
+	if err := s.store.StoreAccount(entry.WalletID, auditEntryID(entry.WalletID, entry.Sequence), data); err != nil {
+		return errors.Wrap(err, "failed to store audit entry")
+	}
+	data, err = json.Marshal(&auditHead{
+		id:       auditHeadID(entry.WalletID),
+		sequence: entry.Sequence,
+		hash:     entry.Hash,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal audit head")
+	}
+	if err := s.store.StoreAccount(entry.WalletID, auditHeadID(entry.WalletID), data); err != nil {
+		return errors.Wrap(err, "failed to store audit head")
+	}
+
+	return nil
+}
+
+// ensureLog ensures that the store has a log for the wallet, under which
+// its entries are held.  The log takes the place of the wallet in the store,
+// so this fails if the store holds the wallet itself.
+// This must be called with the mutex held.
+func (s *storeAuditSink) ensureLog(walletID uuid.UUID) error {
+	if s.logs[walletID] {
+		return nil
+	}
+
+	data, err := s.store.RetrieveWalletByID(walletID)
+	if err != nil {
+		// No log yet.
+		data, err = json.Marshal(&auditLogJSON{
+			UUID:   walletID,
+			Name:   walletID.String(),
+			Record: auditLogRecordType,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal audit log")
+		}
+		if err := s.store.StoreWallet(walletID, walletID.String(), data); err != nil {
+			return errors.Wrap(err, "failed to store audit log")
+		}
+	} else {
+		log := &auditLogJSON{}
+		if err := json.Unmarshal(data, log); err != nil || log.Record != auditLogRecordType {
+			return fmt.Errorf("audit store holds wallet %s; a separate store is required", walletID)
+		}
+	}
+	s.logs[walletID] = true
+
+	return nil
+}
+
+// VerifyAuditLog verifies the audit log for a wallet kept in a store by a
+// store audit sink, returning the entries in the log.  It returns ErrAuditLogTampered if
+// any entry has been modified, removed or added, or if the log has been
+// truncated.
+//
+// Truncation is detected by a head record that refers to the last entry.  A
+// log that has been entirely rewritten, including its head, can only be
+// detected by comparing the hash of the last entry with a copy held
+// elsewhere.
+func VerifyAuditLog(_ context.Context, store e2wtypes.Store, walletID uuid.UUID) ([]*AuditEntry, error) {
+	head, err := retrieveAuditHead(store, walletID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*AuditEntry, 0, head.sequence)
+	prevHash := make([]byte, 32)
+	for sequence := uint64(1); sequence <= head.sequence; sequence++ {
+		data, err := store.RetrieveAccount(walletID, auditEntryID(walletID, sequence))
+		if err != nil {
+			return nil, newError(ErrAuditLogTampered, "audit entry %d missing", sequence)
+		}
+		entry := &AuditEntry{}
+		if err := json.Unmarshal(data, entry); err != nil {
+			return nil, newError(ErrAuditLogTampered, "audit entry %d invalid: %v", sequence, err)
+		}
+		if entry.Sequence != sequence || entry.WalletID != walletID {
+			return nil, newError(ErrAuditLogTampered, "audit entry %d out of place", sequence)
+		}
+		if !bytes.Equal(entry.PrevHash, prevHash) {
+			return nil, newError(ErrAuditLogTampered, "audit entry %d does not follow entry %d", sequence, sequence-1)
+		}
+		if !bytes.Equal(entry.Hash, entry.calculateHash()) {
+			return nil, newError(ErrAuditLogTampered, "audit entry %d modified", sequence)
+		}
+		entries = append(entries, entry)
+		prevHash = entry.Hash
+	}
+
+	if !bytes.Equal(head.hash, prevHash) {
+		return nil, newError(ErrAuditLogTampered, "audit head does not match entry %d", head.sequence)
+	}
+	if _, err := store.RetrieveAccount(walletID, auditEntryID(walletID, head.sequence+1)); err == nil {
+		return nil, newError(ErrAuditLogTampered, "audit log truncated after entry %d", head.sequence)
+	}
+
+	return entries, nil
+}
+
+// calculateHash calculates the hash of the entry, which covers all of its
+// fields including the hash of the previous entry.
+func (e *AuditEntry) calculateHash() []byte {
+	var buf bytes.Buffer
+	buf.Write(e.PrevHash)
+	_ = binary.Write(&buf, binary.BigEndian, e.Sequence)
+	_ = binary.Write(&buf, binary.BigEndian, e.Timestamp.UnixNano())
+	writeLengthPrefixed(&buf, []byte(e.Operation))
+	buf.Write(e.WalletID[:])
+	buf.Write(e.AccountID[:])
+	writeLengthPrefixed(&buf, e.PublicKey)
+	writeLengthPrefixed(&buf, e.Digest)
+	hash := sha256.Sum256(buf.Bytes())
+
+	return hash[:]
+}
+
+// writeLengthPrefixed writes data to a buffer preceded by its length.
+func writeLengthPrefixed(buf *bytes.Buffer, data []byte) {
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
+// auditHead refers to the last entry in an audit log.
+type auditHead struct {
+	id       uuid.UUID
+	sequence uint64
+	hash     []byte
+}
+
+// retrieveAuditHead retrieves the head of the audit log for a wallet.
+// An empty log has a sequence of 0 and a zero hash.
+func retrieveAuditHead(store e2wtypes.Store, walletID uuid.UUID) (*auditHead, error) {
+	head := &auditHead{
+		id:   auditHeadID(walletID),
+		hash: make([]byte, 32),
+	}
+	data, err := store.RetrieveAccount(walletID, head.id)
+	if err != nil {
+		// No log yet.  If entries exist without a head then the log
+		// will fail verification.
+		return head, nil
+	}
+	if err := json.Unmarshal(data, head); err != nil {
+		return nil, newError(ErrAuditLogTampered, "audit head invalid: %v", err)
+	}
+
+	return head, nil
+}
+
+// auditEntryID provides the ID under which an audit entry is stored.
+func auditEntryID(walletID uuid.UUID, sequence uint64) uuid.UUID {
+	return uuid.NewSHA1(walletID, []byte(fmt.Sprintf("%s %d", auditEntryRecordType, sequence)))
+}
+
+// auditHeadID provides the ID under which the audit head is stored.
+func auditHeadID(walletID uuid.UUID) uuid.UUID {
+	return uuid.NewSHA1(walletID, []byte(auditHeadRecordType))
+}
+
+// audit records an operation on an account with the wallet's audit sink,
+// if it has one.  data is the signed data, for AuditSign.
+func (w *wallet) audit(ctx context.Context, operation AuditOperation, a *account, data []byte) error {
+	if w == nil || w.auditSink == nil {
+		return nil
+	}
+
+	entry := &AuditEntry{
+		Timestamp: w.clock(),
+		Operation: operation,
+		WalletID:  w.id,
+		AccountID: a.id,
+		PublicKey: a.publicKey.Marshal(),
+	}
+	if data != nil {
+		digest := sha256.Sum256(data)
+		entry.Digest = digest[:]
+	}
+	if err := w.auditSink.Record(ctx, entry); err != nil {
+		return errors.Wrap(err, "failed to record audit entry")
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+type auditLogJSON struct {
+	UUID   uuid.UUID `json:"uuid"`
+	Name   string    `json:"name"`
+	Record string    `json:"record"`
+}
+
+type auditEntryJSON struct {
+	UUID      uuid.UUID `json:"uuid"`
+	Record    string    `json:"record"`
+	Sequence  string    `json:"sequence"`
+	Timestamp string    `json:"timestamp"`
+	Operation string    `json:"operation"`
+	WalletID  uuid.UUID `json:"wallet"`
+	AccountID uuid.UUID `json:"account"`
+	PublicKey string    `json:"pubkey"`
+	Digest    string    `json:"digest,omitempty"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash"`
+}
+
+// MarshalJSON implements custom JSON marshaller.
+func (e *AuditEntry) MarshalJSON() ([]byte, error) {
+	data := &auditEntryJSON{
+		UUID:      auditEntryID(e.WalletID, e.Sequence),
+		Record:    auditEntryRecordType,
+		Sequence:  strconv.FormatUint(e.Sequence, 10),
+		Timestamp: e.Timestamp.UTC().Format(time.RFC3339Nano),
+		Operation: string(e.Operation),
+		WalletID:  e.WalletID,
+		AccountID: e.AccountID,
+		PublicKey: fmt.Sprintf("%#x", e.PublicKey),
+		PrevHash:  fmt.Sprintf("%#x", e.PrevHash),
+		Hash:      fmt.Sprintf("%#x", e.Hash),
+	}
+	if e.Digest != nil {
+		data.Digest = fmt.Sprintf("%#x", e.Digest)
+	}
+	res, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal JSON")
+	}
+
+	return res, nil
+}
+
+// UnmarshalJSON implements custom JSON unmarshaller.
+func (e *AuditEntry) UnmarshalJSON(input []byte) error {
+	data := auditEntryJSON{}
+	if err := json.Unmarshal(input, &data); err != nil {
+		return errors.Wrap(err, "invalid JSON")
+	}
+	if data.Record != auditEntryRecordType {
+		return errors.New("not an audit entry")
+	}
+	var err error
+	if e.Sequence, err = strconv.ParseUint(data.Sequence, 10, 64); err != nil {
+		return errors.Wrap(err, "invalid sequence")
+	}
+	if e.Timestamp, err = time.Parse(time.RFC3339Nano, data.Timestamp); err != nil {
+		return errors.Wrap(err, "invalid timestamp")
+	}
+	e.Operation = AuditOperation(data.Operation)
+	e.WalletID = data.WalletID
+	e.AccountID = data.AccountID
+	if e.PublicKey, err = parseHex(data.PublicKey); err != nil {
+		return errors.Wrap(err, "invalid pubkey")
+	}
+	if data.Digest != "" {
+		if e.Digest, err = parseHex(data.Digest); err != nil {
+			return errors.Wrap(err, "invalid digest")
+		}
+	}
+	if e.PrevHash, err = parseHex(data.PrevHash); err != nil {
+		return errors.Wrap(err, "invalid previous hash")
+	}
+	if e.Hash, err = parseHex(data.Hash); err != nil {
+		return errors.Wrap(err, "invalid hash")
+	}
+
+	return nil
+}
+
+type auditHeadJSON struct {
+	UUID     uuid.UUID `json:"uuid"`
+	Record   string    `json:"record"`
+	Sequence string    `json:"sequence"`
+	Hash     string    `json:"hash"`
+}
+
+func (h *auditHead) MarshalJSON() ([]byte, error) {
+	res, err := json.Marshal(&auditHeadJSON{
+		UUID:     h.id,
+		Record:   auditHeadRecordType,
+		Sequence: strconv.FormatUint(h.sequence, 10),
+		Hash:     fmt.Sprintf("%#x", h.hash),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal JSON")
+	}
+
+	return res, nil
+}
+
+func (h *auditHead) UnmarshalJSON(input []byte) error {
+	data := auditHeadJSON{}
+	if err := json.Unmarshal(input, &data); err != nil {
+		return errors.Wrap(err, "invalid JSON")
+	}
+	if data.Record != auditHeadRecordType {
+		return errors.New("not an audit head")
+	}
+	h.id = data.UUID
+	var err error
+	if h.sequence, err = strconv.ParseUint(data.Sequence, 10, 64); err != nil {
+		return errors.Wrap(err, "invalid sequence")
+	}
+	if h.hash, err = parseHex(data.Hash); err != nil {
+		return errors.Wrap(err, "invalid hash")
+	}
+
+	return nil
+}
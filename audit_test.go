@@ -0,0 +1,268 @@
+// Copyright © 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	keystorev4 "github.com/wealdtech/go-eth2-wallet-encryptor-keystorev4"
+	nd "github.com/wealdtech/go-eth2-wallet-nd/v2"
+	scratch "github.com/wealdtech/go-eth2-wallet-store-scratch"
+	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// auditRecords provides the stored audit records for a wallet, keyed by
+// sequence, along with the ID of the head record.
+func auditRecords(t *testing.T, store e2wtypes.Store, walletID uuid.UUID) (map[string]map[string]any, uuid.UUID) {
+	t.Helper()
+
+	entries := make(map[string]map[string]any)
+	var headID uuid.UUID
+	for data := range store.RetrieveAccounts(walletID) {
+		record := make(map[string]any)
+		require.NoError(t, json.Unmarshal(data, &record))
+		switch record["record"] {
+		case "audit-entry":
+			entries[record["sequence"].(string)] = record
+		case "audit-head":
+			headID = uuid.MustParse(record["uuid"].(string))
+		}
+	}
+
+	return entries, headID
+}
+
+// storeRecord stores a modified record.
+func storeRecord(t *testing.T, store e2wtypes.Store, walletID uuid.UUID, record map[string]any) {
+	t.Helper()
+
+	data, err := json.Marshal(record)
+	require.NoError(t, err)
+	require.NoError(t, store.StoreAccount(walletID, uuid.MustParse(record["uuid"].(string)), data))
+}
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	auditStore := scratch.New()
+	encryptor := keystorev4.New()
+	now := time.Date(2023, 1, 2, 3, 4, 5, 6, time.UTC)
+	wallet, err := nd.CreateWallet(ctx, "test wallet", store, encryptor,
+		nd.WithAuditSink(nd.NewStoreAuditSink(auditStore)),
+		nd.WithClock(func() time.Time { return now }),
+	)
+	require.NoError(t, err)
+	require.NoError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	// Empty log.
+	entries, err := nd.VerifyAuditLog(ctx, auditStore, wallet.ID())
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	account, err := wallet.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account", []byte("pass"))
+	require.NoError(t, err)
+	_, err = wallet.(e2wtypes.WalletAccountImporter).ImportAccount(ctx,
+		"imported",
+		_byteArray("220091d10843519cd1c452a4ec721d378d7d4c5ece81c4b5556092d410e5e0e1"),
+		[]byte("pass"),
+	)
+	require.NoError(t, err)
+	// Failed unlocks and signs are not recorded.
+	require.Error(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("wrong")))
+	_, err = account.(e2wtypes.AccountSigner).Sign(ctx, []byte("data"))
+	require.Error(t, err)
+	require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("pass")))
+	_, err = account.(e2wtypes.AccountSigner).Sign(ctx, []byte("data"))
+	require.NoError(t, err)
+
+	entries, err = nd.VerifyAuditLog(ctx, auditStore, wallet.ID())
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	expected := []nd.AuditOperation{nd.AuditCreateAccount, nd.AuditImportAccount, nd.AuditUnlock, nd.AuditSign}
+	for i, entry := range entries {
+		require.Equal(t, uint64(i+1), entry.Sequence)
+		require.Equal(t, expected[i], entry.Operation)
+		require.True(t, now.Equal(entry.Timestamp))
+		require.Equal(t, wallet.ID(), entry.WalletID)
+	}
+	require.Equal(t, account.ID(), entries[3].AccountID)
+	require.Equal(t, account.(e2wtypes.AccountPublicKeyProvider).PublicKey().Marshal(), entries[3].PublicKey)
+	digest := sha256.Sum256([]byte("data"))
+	require.Equal(t, digest[:], entries[3].Digest)
+	require.Nil(t, entries[2].Digest)
+
+	// The log is not held alongside the wallet's accounts.
+	records := 0
+	for range store.RetrieveAccounts(wallet.ID()) {
+		records++
+	}
+	require.Equal(t, 2, records)
+
+	// Logs for other wallets are empty.
+	entries, err = nd.VerifyAuditLog(ctx, auditStore, uuid.New())
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestAuditLogTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, store e2wtypes.Store, walletID uuid.UUID)
+		err    string
+	}{
+		{
+			name: "Modified",
+			tamper: func(t *testing.T, store e2wtypes.Store, walletID uuid.UUID) {
+				entries, _ := auditRecords(t, store, walletID)
+				entries["2"]["operation"] = "sign"
+				storeRecord(t, store, walletID, entries["2"])
+			},
+			err: "audit entry 2 modified",
+		},
+		{
+			name: "Replaced",
+			tamper: func(t *testing.T, store e2wtypes.Store, walletID uuid.UUID) {
+				entries, _ := auditRecords(t, store, walletID)
+				storeRecord(t, store, walletID, map[string]any{
+					"uuid":   entries["2"]["uuid"],
+					"record": "removed",
+				})
+			},
+			err: "audit entry 2 invalid: not an audit entry",
+		},
+		{
+			name: "Reordered",
+			tamper: func(t *testing.T, store e2wtypes.Store, walletID uuid.UUID) {
+				entries, _ := auditRecords(t, store, walletID)
+				entry2 := entries["2"]
+				entry3 := entries["3"]
+				entry2["uuid"], entry3["uuid"] = entry3["uuid"], entry2["uuid"]
+				storeRecord(t, store, walletID, entry2)
+				storeRecord(t, store, walletID, entry3)
+			},
+			err: "audit entry 2 out of place",
+		},
+		{
+			name: "Truncated",
+			tamper: func(t *testing.T, store e2wtypes.Store, walletID uuid.UUID) {
+				entries, headID := auditRecords(t, store, walletID)
+				storeRecord(t, store, walletID, map[string]any{
+					"uuid":     headID.String(),
+					"record":   "audit-head",
+					"sequence": "2",
+					"hash":     entries["2"]["hash"],
+				})
+			},
+			err: "audit log truncated after entry 2",
+		},
+		{
+			name: "HeadModified",
+			tamper: func(t *testing.T, store e2wtypes.Store, walletID uuid.UUID) {
+				_, headID := auditRecords(t, store, walletID)
+				storeRecord(t, store, walletID, map[string]any{
+					"uuid":     headID.String(),
+					"record":   "audit-head",
+					"sequence": "3",
+					"hash":     "0x0000000000000000000000000000000000000000000000000000000000000000",
+				})
+			},
+			err: "audit head does not match entry 3",
+		},
+		{
+			name: "HeadRemoved",
+			tamper: func(t *testing.T, store e2wtypes.Store, walletID uuid.UUID) {
+				_, headID := auditRecords(t, store, walletID)
+				storeRecord(t, store, walletID, map[string]any{
+					"uuid":   headID.String(),
+					"record": "removed",
+				})
+			},
+			err: "audit head invalid: not an audit head",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := scratch.New()
+			auditStore := scratch.New()
+			sink := nd.NewStoreAuditSink(auditStore)
+			wallet, err := nd.CreateWallet(ctx, "test wallet", store, keystorev4.New(), nd.WithAuditSink(sink))
+			require.NoError(t, err)
+			require.NoError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+			account, err := wallet.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account", []byte("pass"))
+			require.NoError(t, err)
+			require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("pass")))
+			_, err = account.(e2wtypes.AccountSigner).Sign(ctx, []byte("data"))
+			require.NoError(t, err)
+			_, err = nd.VerifyAuditLog(ctx, auditStore, wallet.ID())
+			require.NoError(t, err)
+
+			test.tamper(t, auditStore, wallet.ID())
+			_, err = nd.VerifyAuditLog(ctx, auditStore, wallet.ID())
+			require.ErrorIs(t, err, nd.ErrAuditLogTampered)
+			require.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestAuditLogWalletStore(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	wallet, err := nd.CreateWallet(ctx, "test wallet", store, keystorev4.New(),
+		nd.WithAuditSink(nd.NewStoreAuditSink(store)),
+	)
+	require.NoError(t, err)
+	require.NoError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	_, err = wallet.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account", []byte("pass"))
+	require.EqualError(t, err, fmt.Sprintf("failed to record audit entry: audit store holds wallet %s; a separate store is required", wallet.ID()))
+}
+
+// failingAuditSink is an audit sink that always fails.
+type failingAuditSink struct{}
+
+func (*failingAuditSink) Record(_ context.Context, _ *nd.AuditEntry) error {
+	return errors.New("sink failed")
+}
+
+func TestAuditSinkFailure(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	wallet, err := nd.CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+	account, err := wallet.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account", []byte("pass"))
+	require.NoError(t, err)
+
+	wallet, err = nd.OpenWallet(ctx, "test wallet", store, encryptor, nd.WithAuditSink(&failingAuditSink{}))
+	require.NoError(t, err)
+	account, err = wallet.(e2wtypes.WalletAccountByIDProvider).AccountByID(ctx, account.ID())
+	require.NoError(t, err)
+
+	// Unlocking fails, and leaves the account locked.
+	require.EqualError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("pass")), "failed to record audit entry: sink failed")
+	unlocked, err := account.(e2wtypes.AccountLocker).IsUnlocked(ctx)
+	require.NoError(t, err)
+	require.False(t, unlocked)
+}
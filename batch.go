@@ -16,8 +16,14 @@ package nd
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -35,57 +41,310 @@ type batch struct {
 	entries   []*batchEntry
 	crypto    map[string]any
 	encryptor e2wtypes.Encryptor
+	// stale is set if accounts in the batch have since been deleted.
+	// It is set when the batch is loaded, and when accounts are deleted.
+	stale bool
+	// removed is set for the record that replaces a removed batch in stores
+	// that cannot remove batches.
+	removed bool
+}
+
+// BatchRemover is the interface for stores that can remove batches.
+// Batches in stores that do not implement it, and named batches, are
+// replaced with a record marking them as removed.
+type BatchRemover interface {
+	// RemoveBatch removes the batch for the given wallet.
+	RemoveBatch(ctx context.Context, walletID uuid.UUID) error
 }
 
 // BatchWallet encrypts all accounts in to a single file, allowing for faster
 // decryption of wallets with large numbers of accounts.
 func (w *wallet) BatchWallet(ctx context.Context, passphrases []string, batchPassphrase string) error {
+	return w.BatchWalletWithOptions(ctx, passphrases, batchPassphrase)
+}
+
+// BatchWalletWithOptions is BatchWallet with options to control how the batch
+// is built.  Accounts are decrypted in parallel, and cancelling the context
+// stops the batch from being built.
+func (w *wallet) BatchWalletWithOptions(ctx context.Context,
+	passphrases []string,
+	batchPassphrase string,
+	opts ...BatchOption,
+) error {
 	w.batchMutex.Lock()
 	defer w.batchMutex.Unlock()
 
-	batchStorer, isBatchStorer := w.store.(e2wtypes.BatchStorer)
-	if !isBatchStorer {
-		return fmt.Errorf("store %s cannot store batches", w.store.Name())
+	if err := w.canStoreBatches(w.batchName); err != nil {
+		return err
 	}
 
-	accounts := make([]*account, 0, 1024)
+	return w.buildBatch(ctx, &batch{}, nil, passphrases, batchPassphrase, parseBatchOptions(opts))
+}
+
+// UpdateBatch updates the existing batch for the wallet, rather than
+// recreating it from scratch as BatchWallet does.  The existing batch is
+// decrypted with the batch passphrase, and only accounts that are not already
+// in the batch are decrypted with the supplied passphrases and added to it.
+// Accounts that have since been deleted are dropped from the batch.
+//
+// If the wallet does not have a batch this is the same as BatchWallet.  As
+// with BatchWallet, the wallet needs to be re-opened to use the updated batch.
+// Filters are not stored with the batch, so to update a batch of a subset of
+// accounts the same filters must be supplied again.
+func (w *wallet) UpdateBatch(ctx context.Context,
+	passphrases []string,
+	batchPassphrase string,
+	opts ...BatchOption,
+) error {
+	w.batchMutex.Lock()
+	defer w.batchMutex.Unlock()
+
+	if err := w.canStoreBatches(w.batchName); err != nil {
+		return err
+	}
+
+	// Obtain the existing batch directly from the store, as it may have
+	// changed since the wallet was opened.
+	existing, err := w.retrieveStoredBatch(ctx, w.batchName)
+	if err != nil {
+		return err
+	}
+	var existingKeys []byte
+	if existing.crypto != nil {
+		payload, err := existing.encryptor.Decrypt(existing.crypto, batchPassphrase)
+		if err != nil {
+			return newError(ErrIncorrectPassphrase, "unable to decrypt batch with batch passphrase")
+		}
+		defer zeroBytes(payload)
+		existingKeys, err = existing.secretKeys(payload)
+		if err != nil {
+			return err
+		}
+	}
 
-	// Obtain and decrypt individual accounts directly from store.
+	return w.buildBatch(ctx, existing, existingKeys, passphrases, batchPassphrase, parseBatchOptions(opts))
+}
+
+// buildBatch builds and stores a batch from the accounts in the store that
+// pass the filters in the options, taking keys from an existing batch where
+// possible and decrypting the remaining accounts with the supplied
+// passphrases.
+func (w *wallet) buildBatch(ctx context.Context,
+	existing *batch,
+	existingKeys []byte,
+	passphrases []string,
+	batchPassphrase string,
+	options *batchOptions,
+) error {
+	batched := make(map[uuid.UUID]int, len(existing.entries))
+	for i, entry := range existing.entries {
+		batched[entry.id] = i
+	}
+
+	// Work through the accounts in the store, taking keys from the existing
+	// batch where possible.  Deleted accounts are not returned by the store,
+	// so are dropped.
+	kept := make(map[int]*batchEntry, len(existing.entries))
+	accounts := make([]*account, 0, 1024)
 	for data := range w.store.RetrieveAccounts(w.ID()) {
-		if account, err := deserializeAccount(w, data); err == nil {
-			unlocked := false
-			for _, passphrase := range passphrases {
-				if err := account.Unlock(ctx, []byte(passphrase)); err == nil {
-					unlocked = true
-					break
-				}
-			}
-			if !unlocked {
-				return fmt.Errorf("unable to decrypt account %q with supplied passphrases", account.name)
+		account, err := deserializeAccount(w, data)
+		if err != nil || !options.includes(account) {
+			continue
+		}
+		pubkey := account.publicKey.Marshal()
+		if i, exists := batched[account.id]; exists && bytes.Equal(existing.entries[i].pubkey, pubkey) {
+			kept[i] = &batchEntry{
+				id:     account.id,
+				name:   account.name,
+				pubkey: pubkey,
 			}
+			continue
+		}
+		accounts = append(accounts, account)
+	}
 
-			accounts = append(accounts, account)
+	// The store returns accounts in no particular order, so add the kept
+	// entries in their order in the existing batch.
+	entries := make([]*batchEntry, 0, len(kept)+len(accounts))
+	secretKeys := make([]byte, 0, 32*(len(kept)+len(accounts)))
+	for i := range existing.entries {
+		if entry, exists := kept[i]; exists {
+			entries = append(entries, entry)
+			secretKeys = append(secretKeys, existingKeys[i*32:(i+1)*32]...)
 		}
 	}
 
-	batchEntries := make([]*batchEntry, len(accounts))
-	secretKeys := make([]byte, 0, 32*len(accounts))
+	privateKeys, err := decryptAccounts(ctx, accounts, passphrases, options)
+	if err != nil {
+		zeroBytes(secretKeys)
+		return err
+	}
+
+	// Existing entries keep their order, with new entries after them.
 	for i, account := range accounts {
-		batchEntries[i] = &batchEntry{
+		entries = append(entries, &batchEntry{
 			id:     account.id,
 			name:   account.name,
 			pubkey: account.publicKey.Marshal(),
+		})
+		secretKeys = append(secretKeys, privateKeys[i].Marshal()...)
+		zeroizeKey(privateKeys[i])
+	}
+
+	return w.storeBatch(ctx, entries, secretKeys, batchPassphrase)
+}
+
+// decryptAccounts decrypts the private keys of accounts in parallel.
+// If the options contain a passphrase resolver, each account is decrypted
+// once with its passphrase and all failures are reported; otherwise each
+// account is decrypted with the first of the supplied passphrases that works,
+// stopping at the first failure.
+func decryptAccounts(ctx context.Context,
+	accounts []*account,
+	passphrases []string,
+	options *batchOptions,
+) (
+	[]e2types.PrivateKey,
+	error,
+) {
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	privateKeys := make([]e2types.PrivateKey, len(accounts))
+	errs := make([]error, len(accounts))
+	var progressMutex sync.Mutex
+	done := 0
+	runWorkers(len(accounts), options.workers, func(i int) {
+		if workCtx.Err() != nil {
+			return
+		}
+		privateKeys[i], errs[i] = decryptAccount(accounts[i], passphrases, options.resolver)
+		if errs[i] != nil && options.resolver == nil {
+			// No point in decrypting the remaining accounts.
+			cancel()
+
+			return
+		}
+		if options.progress != nil {
+			progressMutex.Lock()
+			done++
+			options.progress(done, len(accounts))
+			progressMutex.Unlock()
+		}
+	})
+
+	err := ctx.Err()
+	if err != nil {
+		err = errors.Wrap(err, "batch cancelled")
+	}
+	failures := make([]string, 0)
+	for i := range errs {
+		if errs[i] == nil {
+			continue
+		}
+		if options.resolver == nil {
+			err = errs[i]
+			break
+		}
+		failures = append(failures, fmt.Sprintf("%q: %v", accounts[i].name, errs[i]))
+	}
+	if len(failures) > 0 {
+		// Accounts are not in any particular order, so sort them by name.
+		sort.Strings(failures)
+		err = newError(ErrIncorrectPassphrase, "unable to decrypt accounts: %s", strings.Join(failures, "; "))
+	}
+	if err != nil {
+		for _, privateKey := range privateKeys {
+			if privateKey != nil {
+				zeroizeKey(privateKey)
+			}
+		}
+
+		return nil, err
+	}
+
+	return privateKeys, nil
+}
+
+// decryptAccount decrypts the private key of an account, with the passphrase
+// from the resolver if supplied or else the first of the passphrases that
+// works.
+func decryptAccount(a *account, passphrases []string, resolver PassphraseResolver) (e2types.PrivateKey, error) {
+	if resolver != nil {
+		passphrase, err := resolver(a.id, a.name, a.publicKey.Marshal())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to obtain passphrase")
 		}
-		secretKeys = append(secretKeys, account.secretKey.Marshal()...)
+
+		return a.decryptPrivateKey([]byte(passphrase))
+	}
+
+	for _, passphrase := range passphrases {
+		if privateKey, err := a.decryptPrivateKey([]byte(passphrase)); err == nil {
+			return privateKey, nil
+		}
+	}
+
+	return nil, newError(ErrIncorrectPassphrase, "unable to decrypt account %q with supplied passphrases", a.name)
+}
+
+// PassphraseResolver provides the passphrase for an account when building a
+// batch, given its ID, name and public key.  It may be called concurrently.
+type PassphraseResolver func(id uuid.UUID, name string, pubkey []byte) (string, error)
+
+// PassphraseMap creates a passphrase resolver from a map of passphrases.
+// Each account's passphrase is looked up by its ID, then its name, then its
+// public key as a 0x-prefixed hex string.
+func PassphraseMap(passphrases map[string]string) PassphraseResolver {
+	return func(id uuid.UUID, name string, pubkey []byte) (string, error) {
+		for _, key := range []string{id.String(), name, fmt.Sprintf("%#x", pubkey)} {
+			if passphrase, exists := passphrases[key]; exists {
+				return passphrase, nil
+			}
+		}
+
+		return "", errors.New("no passphrase for account")
 	}
+}
 
-	crypto, err := w.encryptor.Encrypt(secretKeys, batchPassphrase)
+// retrieveStoredBatch retrieves the named batch for the wallet, or the
+// default batch if the name is empty, directly from the store.  If there is
+// no batch, or it has been removed, an empty batch is returned.
+func (w *wallet) retrieveStoredBatch(ctx context.Context, name string) (*batch, error) {
+	data, err := w.retrieveBatchData(ctx, name)
+	if err != nil {
+		// No batch.
+		return &batch{}, nil
+	}
+	res := &batch{}
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal batch")
+	}
+	if res.removed {
+		return &batch{}, nil
+	}
+
+	return res, nil
+}
+
+// storeBatch encrypts the secret keys for a set of batch entries, along with
+// a digest of the entries, and stores the resultant batch.  The secret keys
+// are zeroed.
+func (w *wallet) storeBatch(ctx context.Context,
+	entries []*batchEntry,
+	secretKeys []byte,
+	batchPassphrase string,
+) error {
+	payload := batchPayload(entries, secretKeys)
+	zeroBytes(secretKeys)
+	crypto, err := w.encryptor.Encrypt(payload, batchPassphrase)
+	zeroBytes(payload)
 	if err != nil {
 		return errors.Wrap(err, "failed to encrypt batch")
 	}
 
 	data := &batch{
-		entries:   batchEntries,
+		entries:   entries,
 		crypto:    crypto,
 		encryptor: w.encryptor,
 	}
@@ -93,13 +352,97 @@ func (w *wallet) BatchWallet(ctx context.Context, passphrases []string, batchPas
 	if err != nil {
 		return errors.Wrap(err, "failed to marshal batch")
 	}
-	if err := batchStorer.StoreBatch(ctx, w.id, w.name, batch); err != nil {
-		return errors.Wrap(err, "failed to store batch")
+
+	return w.storeBatchData(ctx, w.batchName, batch)
+}
+
+// RemoveBatch removes the batch for the wallet, after which accounts are
+// obtained and decrypted individually.  Accounts already obtained from the
+// batch keep their keys if unlocked, but will need to be obtained from the
+// wallet again once locked.
+func (w *wallet) RemoveBatch(ctx context.Context) error {
+	w.batchMutex.Lock()
+	defer w.batchMutex.Unlock()
+
+	if batchRemover, isBatchRemover := w.store.(BatchRemover); isBatchRemover && w.batchName == "" {
+		if err := batchRemover.RemoveBatch(ctx, w.id); err != nil {
+			return errors.Wrap(err, "failed to remove batch")
+		}
+	} else {
+		data, err := json.Marshal(&batch{removed: true})
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal batch")
+		}
+		if err := w.storeBatchData(ctx, w.batchName, data); err != nil {
+			return err
+		}
+	}
+
+	// Unload accounts obtained from the batch, so that they are obtained
+	// from the store when next required.
+	if w.batch != nil {
+		for _, entry := range w.batch.entries {
+			if account, exists := w.loadedAccount(entry.id); exists && account.crypto == nil {
+				w.unloadAccount(entry.id)
+			}
+		}
 	}
+	w.batch = &batch{}
+	w.clearBatchKeys()
 
 	return nil
 }
 
+// batchPayloadMarker starts the encrypted payload of batches that contain a
+// digest of their entries.  It is not a valid private key, so cannot be
+// mistaken for the first key of an older batch without a digest.
+var batchPayloadMarker = bytes.Repeat([]byte{0xff}, 32)
+
+// batchPayload provides the data to encrypt for a batch: the marker, the
+// digest of the entries and the secret keys.
+func batchPayload(entries []*batchEntry, secretKeys []byte) []byte {
+	payload := make([]byte, 0, 64+len(secretKeys))
+	payload = append(payload, batchPayloadMarker...)
+	payload = append(payload, entriesDigest(entries)...)
+	payload = append(payload, secretKeys...)
+
+	return payload
+}
+
+// entriesDigest provides a digest of the IDs, names and public keys of the
+// entries, in order.
+func entriesDigest(entries []*batchEntry) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(entries)))
+	for _, entry := range entries {
+		buf.Write(entry.id[:])
+		writeLengthPrefixed(&buf, []byte(entry.name))
+		writeLengthPrefixed(&buf, entry.pubkey)
+	}
+	digest := sha256.Sum256(buf.Bytes())
+
+	return digest[:]
+}
+
+// secretKeys provides the secret keys from the decrypted payload of the
+// batch, returning ErrBatchTampered if the payload does not match the
+// entries.  Older batches without a digest can only be checked for length.
+func (b *batch) secretKeys(payload []byte) ([]byte, error) {
+	if !bytes.HasPrefix(payload, batchPayloadMarker) {
+		if len(payload) != 32*len(b.entries) {
+			return nil, newError(ErrBatchTampered, "batch entries do not match batch data")
+		}
+
+		return payload, nil
+	}
+
+	if len(payload) != 64+32*len(b.entries) || !bytes.Equal(payload[32:64], entriesDigest(b.entries)) {
+		return nil, newError(ErrBatchTampered, "batch entries do not match batch data")
+	}
+
+	return payload[64:], nil
+}
+
 // retrieveAccountsBatch retrieves the batched accounts for a wallet.
 func (w *wallet) retrieveAccountsBatch(ctx context.Context) error {
 	w.batchMutex.Lock()
@@ -114,12 +457,7 @@ func (w *wallet) retrieveAccountsBatch(ctx context.Context) error {
 	// keep coming back and trying again.
 	w.batch = &batch{}
 
-	batchRetriever, isBatchRetriever := w.store.(e2wtypes.BatchRetriever)
-	if !isBatchRetriever {
-		return errors.New("not a batch retriever")
-	}
-
-	serializedBatch, err := batchRetriever.RetrieveBatch(ctx, w.id)
+	serializedBatch, err := w.retrieveBatchData(ctx, w.batchName)
 	if err != nil {
 		return errors.Wrap(err, "failed to retrieve batch")
 	}
@@ -127,64 +465,184 @@ func (w *wallet) retrieveAccountsBatch(ctx context.Context) error {
 	if err := json.Unmarshal(serializedBatch, res); err != nil {
 		return errors.Wrap(err, "failed to unmarshal batch")
 	}
+	if res.removed {
+		// Batch has been removed; leave the marker in place.
+		return nil
+	}
 	w.batch = res
 
+	status, err := w.compareBatch(res)
+	if err != nil {
+		return err
+	}
+	res.stale = len(status.Extra) > 0
+
 	// Create individual accounts from the batch.
 	for i := range res.entries {
+		name, exists := w.index.Name(res.entries[i].id)
+		if !exists {
+			// Account has been deleted since the batch was created.
+			continue
+		}
 		publicKey, err := e2types.BLSPublicKeyFromBytes(res.entries[i].pubkey)
 		if err != nil {
 			return errors.Wrap(err, "invalid public key")
 		}
 		account := &account{
-			id:   res.entries[i].id,
-			name: res.entries[i].name,
+			id: res.entries[i].id,
+			// Use the name from the index, in case the account has been
+			// renamed since the batch was created.
+			name: name,
 			// We do not populate crypto, as the secret is in the batch.
 			publicKey: publicKey,
 			version:   version,
 			wallet:    w,
 			encryptor: w.encryptor,
 		}
-		w.accounts[account.id] = account
+		w.setLoadedAccount(account)
+		w.pubkeys.Add(res.entries[i].pubkey, account.id)
 	}
 
 	return nil
 }
 
-// batchDecrypt decrypts a batch of accounts.
-func (w *wallet) batchDecrypt(_ context.Context, passphrase []byte) error {
+// BatchStatus describes how the batch for a wallet differs from the accounts
+// in the wallet.
+type BatchStatus struct {
+	// Present is true if the wallet has a batch.
+	Present bool
+	// Missing contains the IDs of accounts that are not in the batch.
+	Missing []uuid.UUID
+	// Extra contains the IDs of accounts in the batch that have since been
+	// deleted.
+	Extra []uuid.UUID
+	// Renamed contains the IDs of accounts whose name differs from their
+	// name in the batch.
+	Renamed []uuid.UUID
+}
+
+// Current returns true if the batch contains exactly the accounts in the
+// wallet.
+func (s *BatchStatus) Current() bool {
+	return s.Present && len(s.Missing) == 0 && len(s.Extra) == 0 && len(s.Renamed) == 0
+}
+
+// BatchStatus compares the batch for the wallet with the accounts in the
+// wallet.  A batch that is not current can be brought up to date with
+// UpdateBatch.
+func (w *wallet) BatchStatus(ctx context.Context) (*BatchStatus, error) {
+	_ = w.retrieveBatchIfRequired(ctx)
+
 	w.batchMutex.Lock()
 	defer w.batchMutex.Unlock()
 
-	if w.batchDecrypted {
-		// Means the batch was decrypted by another thread; all good.
-		return nil
-	}
+	return w.compareBatch(w.batch)
+}
 
-	if w.batch == nil || w.batch.crypto == nil {
-		return errors.New("no batch to decrypt")
+// compareBatch compares a batch with the accounts index.
+func (w *wallet) compareBatch(b *batch) (*BatchStatus, error) {
+	names, err := w.indexedAccounts()
+	if err != nil {
+		return nil, err
 	}
 
-	secretBytes, err := w.encryptor.Decrypt(w.batch.crypto, string(passphrase))
-	if err != nil {
-		return errors.Wrap(err, "failed to decrypt data")
+	status := &BatchStatus{
+		Present: b != nil && b.crypto != nil,
+		Missing: make([]uuid.UUID, 0),
+		Extra:   make([]uuid.UUID, 0),
+		Renamed: make([]uuid.UUID, 0),
 	}
-	for i := range w.batch.entries {
-		if w.accounts[w.batch.entries[i].id].secretKey != nil {
-			// Already have this key.
-			continue
+	batched := make(map[uuid.UUID]bool)
+	if status.Present {
+		for _, entry := range b.entries {
+			batched[entry.id] = true
+			name, exists := names[entry.id]
+			switch {
+			case !exists:
+				status.Extra = append(status.Extra, entry.id)
+			case name != entry.name:
+				status.Renamed = append(status.Renamed, entry.id)
+			}
 		}
-		secretKey, err := e2types.BLSPrivateKeyFromBytes(secretBytes[i*32 : (i+1)*32])
+	}
+	for id := range names {
+		if !batched[id] {
+			status.Missing = append(status.Missing, id)
+		}
+	}
+	sortIDs(status.Missing)
+	sortIDs(status.Extra)
+	sortIDs(status.Renamed)
+
+	return status, nil
+}
+
+// sortIDs sorts a list of IDs.
+func sortIDs(ids []uuid.UUID) {
+	sort.Slice(ids, func(i int, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+}
+
+// batchDecrypt decrypts the batch if required, and provides the private key
+// of the given account from it.  The decrypted keys are held by the wallet
+// until the batch is cleared, rather than being given to accounts that have
+// not been unlocked, so each account must supply the batch passphrase.
+func (w *wallet) batchDecrypt(_ context.Context, passphrase []byte, id uuid.UUID) (e2types.PrivateKey, error) {
+	w.batchMutex.Lock()
+	defer w.batchMutex.Unlock()
+
+	passphraseHash := sha256.Sum256(passphrase)
+	if w.batchDecrypted {
+		// The batch was decrypted previously, so check the passphrase matches.
+		if subtle.ConstantTimeCompare(passphraseHash[:], w.batchPassphraseHash) != 1 {
+			return nil, newError(ErrIncorrectPassphrase, "failed to decrypt data: incorrect passphrase")
+		}
+	} else {
+		if w.batch == nil || w.batch.crypto == nil {
+			return nil, errors.New("no batch to decrypt")
+		}
+
+		payload, err := w.encryptor.Decrypt(w.batch.crypto, string(passphrase))
 		if err != nil {
-			return errors.Wrap(err, "invalid private key")
+			return nil, newError(ErrIncorrectPassphrase, "failed to decrypt data: %v", err)
 		}
-		publicKey := secretKey.PublicKey()
-		if !bytes.Equal(publicKey.Marshal(), w.accounts[w.batch.entries[i].id].publicKey.Marshal()) {
-			return errors.New("secret key does not correspond to public key")
+		defer zeroBytes(payload)
+		secretBytes, err := w.batch.secretKeys(payload)
+		if err != nil {
+			return nil, err
+		}
+		// Copy the keys, as the payload is cleared.
+		secrets := make([]byte, len(secretBytes))
+		copy(secrets, secretBytes)
+		w.batchKeys = make(map[uuid.UUID][]byte, len(w.batch.entries))
+		for i := range w.batch.entries {
+			w.batchKeys[w.batch.entries[i].id] = secrets[i*32 : (i+1)*32]
 		}
-		w.accounts[w.batch.entries[i].id].secretKey = secretKey
+		w.batchPassphraseHash = passphraseHash[:]
+		w.batchDecrypted = true
 	}
 
-	w.batchDecrypted = true
+	secretBytes, exists := w.batchKeys[id]
+	if !exists {
+		return nil, errors.New("account not in batch")
+	}
+	secretKey, err := e2types.BLSPrivateKeyFromBytes(secretBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid private key")
+	}
 
-	return nil
+	return secretKey, nil
+}
+
+// clearBatchKeys clears the keys obtained from the batch, so that the batch
+// must be decrypted again.
+// This must be called with the batch mutex held.
+func (w *wallet) clearBatchKeys() {
+	for _, key := range w.batchKeys {
+		zeroBytes(key)
+	}
+	w.batchKeys = nil
+	w.batchPassphraseHash = nil
+	w.batchDecrypted = false
 }
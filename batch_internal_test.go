@@ -0,0 +1,699 @@
+// Copyright © 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	keystorev4 "github.com/wealdtech/go-eth2-wallet-encryptor-keystorev4"
+	scratch "github.com/wealdtech/go-eth2-wallet-store-scratch"
+	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+func TestUpdateBatch(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	wlt := w.(*wallet)
+	require.NoError(t, wlt.Unlock(ctx, nil))
+
+	// No batch, so all accounts are decrypted.
+	account1, err := wlt.CreateAccount(ctx, "account 1", []byte("passphrase 1"))
+	require.NoError(t, err)
+	account2, err := wlt.CreateAccount(ctx, "account 2", []byte("passphrase 2"))
+	require.NoError(t, err)
+	require.ErrorIs(t, wlt.UpdateBatch(ctx, []string{"passphrase 1"}, "batch passphrase"), ErrIncorrectPassphrase)
+	require.NoError(t, wlt.UpdateBatch(ctx, []string{"passphrase 1", "passphrase 2"}, "batch passphrase"))
+
+	// Add and delete accounts.
+	account3, err := wlt.CreateAccount(ctx, "account 3", []byte("passphrase 3"))
+	require.NoError(t, err)
+	require.NoError(t, wlt.DeleteAccount(ctx, account1.ID()))
+
+	// Incorrect batch passphrase.
+	require.ErrorIs(t, wlt.UpdateBatch(ctx, []string{"passphrase 3"}, "wrong"), ErrIncorrectPassphrase)
+
+	// Only the new account is decrypted with the supplied passphrases.
+	require.NoError(t, wlt.UpdateBatch(ctx, []string{"passphrase 3"}, "batch passphrase"))
+
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	wlt = w.(*wallet)
+	require.NoError(t, wlt.retrieveBatchIfRequired(ctx))
+	require.False(t, wlt.batch.stale)
+	require.Len(t, wlt.batch.entries, 2)
+	require.Equal(t, account2.ID(), wlt.batch.entries[0].id)
+	require.Equal(t, account3.ID(), wlt.batch.entries[1].id)
+
+	accounts := 0
+	for account := range wlt.Accounts(ctx) {
+		require.NotEqual(t, account1.ID(), account.ID())
+		require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("batch passphrase")))
+		accounts++
+	}
+	require.Equal(t, 2, accounts)
+}
+
+func TestUpdateBatchKeepsOrder(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	wlt := w.(*wallet)
+	require.NoError(t, wlt.Unlock(ctx, nil))
+
+	for i := 0; i < 8; i++ {
+		_, err := wlt.CreateAccount(ctx, fmt.Sprintf("account %d", i), []byte("passphrase"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, wlt.UpdateBatch(ctx, []string{"passphrase"}, "batch passphrase"))
+	stored, err := wlt.retrieveStoredBatch(ctx, "")
+	require.NoError(t, err)
+	ids := make([]uuid.UUID, 0, len(stored.entries))
+	for _, entry := range stored.entries {
+		ids = append(ids, entry.id)
+	}
+
+	// Existing entries keep their order, with new entries after them.
+	account, err := wlt.CreateAccount(ctx, "new account", []byte("passphrase"))
+	require.NoError(t, err)
+	require.NoError(t, wlt.UpdateBatch(ctx, []string{"passphrase"}, "batch passphrase"))
+	stored, err = wlt.retrieveStoredBatch(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, stored.entries, len(ids)+1)
+	for i, id := range ids {
+		require.Equal(t, id, stored.entries[i].id)
+	}
+	require.Equal(t, account.ID(), stored.entries[len(ids)].id)
+
+	// Keys remain with their entries.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	for account := range w.Accounts(ctx) {
+		require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("batch passphrase")))
+	}
+}
+
+func TestBatchStatus(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	wlt := w.(*wallet)
+	require.NoError(t, wlt.Unlock(ctx, nil))
+	account1, err := wlt.CreateAccount(ctx, "account 1", []byte("passphrase"))
+	require.NoError(t, err)
+	account2, err := wlt.CreateAccount(ctx, "account 2", []byte("passphrase"))
+	require.NoError(t, err)
+
+	// No batch.
+	status, err := wlt.BatchStatus(ctx)
+	require.NoError(t, err)
+	require.False(t, status.Present)
+	require.False(t, status.Current())
+	require.Len(t, status.Missing, 2)
+
+	require.NoError(t, wlt.BatchWallet(ctx, []string{"passphrase"}, "batch passphrase"))
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	wlt = w.(*wallet)
+	require.NoError(t, wlt.Unlock(ctx, nil))
+	status, err = wlt.BatchStatus(ctx)
+	require.NoError(t, err)
+	require.True(t, status.Current())
+
+	// Add, delete and rename accounts.
+	account3, err := wlt.CreateAccount(ctx, "account 3", []byte("passphrase"))
+	require.NoError(t, err)
+	require.NoError(t, wlt.DeleteAccount(ctx, account1.ID()))
+	require.NoError(t, wlt.RenameAccount(ctx, account2.ID(), "renamed"))
+
+	status, err = wlt.BatchStatus(ctx)
+	require.NoError(t, err)
+	require.True(t, status.Present)
+	require.False(t, status.Current())
+	require.Equal(t, []uuid.UUID{account3.ID()}, status.Missing)
+	require.Equal(t, []uuid.UUID{account1.ID()}, status.Extra)
+	require.Equal(t, []uuid.UUID{account2.ID()}, status.Renamed)
+
+	// Differences are picked up when the batch is loaded.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	wlt = w.(*wallet)
+	status, err = wlt.BatchStatus(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []uuid.UUID{account3.ID()}, status.Missing)
+	require.Equal(t, []uuid.UUID{account1.ID()}, status.Extra)
+	require.Equal(t, []uuid.UUID{account2.ID()}, status.Renamed)
+	require.True(t, wlt.batch.stale)
+
+	names := make(map[uuid.UUID]string)
+	for account := range wlt.Accounts(ctx) {
+		names[account.ID()] = account.Name()
+	}
+	require.Equal(t, map[uuid.UUID]string{
+		account2.ID(): "renamed",
+		account3.ID(): "account 3",
+	}, names)
+}
+
+func TestBatchTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, b *batch)
+		err    string
+	}{
+		{
+			name: "Good",
+			tamper: func(_ *testing.T, _ *batch) {
+			},
+		},
+		{
+			name: "Reordered",
+			tamper: func(_ *testing.T, b *batch) {
+				b.entries[0], b.entries[1] = b.entries[1], b.entries[0]
+			},
+			err: "failed to decrypt batch: batch entries do not match batch data",
+		},
+		{
+			name: "Renamed",
+			tamper: func(_ *testing.T, b *batch) {
+				b.entries[1].name = "renamed"
+			},
+			err: "failed to decrypt batch: batch entries do not match batch data",
+		},
+		{
+			name: "Removed",
+			tamper: func(_ *testing.T, b *batch) {
+				b.entries = b.entries[:1]
+			},
+			err: "failed to decrypt batch: batch entries do not match batch data",
+		},
+		{
+			name: "WithoutDigest",
+			tamper: func(t *testing.T, b *batch) {
+				// Batches created before entries were bound are still accepted.
+				payload, err := b.encryptor.Decrypt(b.crypto, "batch passphrase")
+				require.NoError(t, err)
+				b.crypto, err = b.encryptor.Encrypt(payload[64:], "batch passphrase")
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := scratch.New()
+			encryptor := keystorev4.New()
+			w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+			require.NoError(t, err)
+			wlt := w.(*wallet)
+			require.NoError(t, wlt.Unlock(ctx, nil))
+			_, err = wlt.CreateAccount(ctx, "account 1", []byte("passphrase"))
+			require.NoError(t, err)
+			_, err = wlt.CreateAccount(ctx, "account 2", []byte("passphrase"))
+			require.NoError(t, err)
+			require.NoError(t, wlt.BatchWallet(ctx, []string{"passphrase"}, "batch passphrase"))
+
+			data, err := store.(e2wtypes.BatchRetriever).RetrieveBatch(ctx, wlt.ID())
+			require.NoError(t, err)
+			b := &batch{}
+			require.NoError(t, json.Unmarshal(data, b))
+			test.tamper(t, b)
+			data, err = json.Marshal(b)
+			require.NoError(t, err)
+			require.NoError(t, store.(e2wtypes.BatchStorer).StoreBatch(ctx, wlt.ID(), wlt.Name(), data))
+
+			w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+			require.NoError(t, err)
+			for acc := range w.Accounts(ctx) {
+				if acc.(*account).crypto != nil {
+					// Not a batch account.
+					continue
+				}
+				err := acc.(e2wtypes.AccountLocker).Unlock(ctx, []byte("batch passphrase"))
+				if test.err != "" {
+					require.ErrorIs(t, err, ErrBatchTampered)
+					require.EqualError(t, err, test.err)
+				} else {
+					require.NoError(t, err)
+				}
+			}
+		})
+	}
+}
+
+// removingStore is a store that can remove batches.
+type removingStore struct {
+	*scratch.Store
+	removed bool
+}
+
+func (s *removingStore) RemoveBatch(_ context.Context, _ uuid.UUID) error {
+	s.removed = true
+
+	return nil
+}
+
+func (s *removingStore) RetrieveBatch(ctx context.Context, walletID uuid.UUID) ([]byte, error) {
+	if s.removed {
+		return nil, errors.New("no batch")
+	}
+
+	return s.Store.RetrieveBatch(ctx, walletID)
+}
+
+func TestRemoveBatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		store e2wtypes.Store
+	}{
+		{
+			name:  "Tombstone",
+			store: scratch.New(),
+		},
+		{
+			name:  "Remover",
+			store: &removingStore{Store: scratch.New().(*scratch.Store)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			encryptor := keystorev4.New()
+			w, err := CreateWallet(ctx, "test wallet", test.store, encryptor)
+			require.NoError(t, err)
+			wlt := w.(*wallet)
+			require.NoError(t, wlt.Unlock(ctx, nil))
+			account1, err := wlt.CreateAccount(ctx, "account 1", []byte("passphrase"))
+			require.NoError(t, err)
+			_, err = wlt.CreateAccount(ctx, "account 2", []byte("passphrase"))
+			require.NoError(t, err)
+			require.NoError(t, wlt.BatchWallet(ctx, []string{"passphrase"}, "batch passphrase"))
+
+			w, err = OpenWallet(ctx, "test wallet", test.store, encryptor)
+			require.NoError(t, err)
+			wlt = w.(*wallet)
+			acc, err := wlt.AccountByID(ctx, account1.ID())
+			require.NoError(t, err)
+			require.Nil(t, acc.(*account).crypto)
+
+			require.NoError(t, wlt.RemoveBatch(ctx))
+			acc, err = wlt.AccountByID(ctx, account1.ID())
+			require.NoError(t, err)
+			require.NotNil(t, acc.(*account).crypto)
+			require.NoError(t, acc.(*account).Unlock(ctx, []byte("passphrase")))
+
+			// Re-open the wallet and confirm the batch is not used.
+			w, err = OpenWallet(ctx, "test wallet", test.store, encryptor)
+			require.NoError(t, err)
+			wlt = w.(*wallet)
+			status, err := wlt.BatchStatus(ctx)
+			require.NoError(t, err)
+			require.False(t, status.Present)
+			accounts := 0
+			for acc := range wlt.Accounts(ctx) {
+				require.NoError(t, acc.(e2wtypes.AccountLocker).Unlock(ctx, []byte("passphrase")))
+				accounts++
+			}
+			require.Equal(t, 2, accounts)
+
+			// A new batch can be created.
+			require.NoError(t, wlt.UpdateBatch(ctx, []string{"passphrase"}, "new batch passphrase"))
+		})
+	}
+}
+
+func TestIgnoreBatch(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(*wallet).Unlock(ctx, nil))
+	account1, err := w.(*wallet).CreateAccount(ctx, "account 1", []byte("passphrase"))
+	require.NoError(t, err)
+	require.NoError(t, w.(*wallet).BatchWallet(ctx, []string{"passphrase"}, "batch passphrase"))
+
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor, WithIgnoreBatch())
+	require.NoError(t, err)
+	acc, err := w.(*wallet).AccountByID(ctx, account1.ID())
+	require.NoError(t, err)
+	require.ErrorIs(t, acc.(*account).Unlock(ctx, []byte("batch passphrase")), ErrIncorrectPassphrase)
+	require.NoError(t, acc.(*account).Unlock(ctx, []byte("passphrase")))
+	status, err := w.(*wallet).BatchStatus(ctx)
+	require.NoError(t, err)
+	require.False(t, status.Present)
+}
+
+func TestUnlockBatchAccount(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(*wallet).Unlock(ctx, nil))
+	account1, err := w.(*wallet).CreateAccount(ctx, "account 1", []byte("passphrase 1"))
+	require.NoError(t, err)
+	account2, err := w.(*wallet).CreateAccount(ctx, "account 2", []byte("passphrase 2"))
+	require.NoError(t, err)
+	require.NoError(t, w.(*wallet).BatchWallet(ctx, []string{"passphrase 1", "passphrase 2"}, "batch passphrase"))
+
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	wlt := w.(*wallet)
+	require.NoError(t, wlt.Unlock(ctx, nil))
+	account3, err := wlt.CreateAccount(ctx, "account 3", []byte("passphrase 3"))
+	require.NoError(t, err)
+
+	acc1, err := wlt.AccountByID(ctx, account1.ID())
+	require.NoError(t, err)
+	acc2, err := wlt.AccountByID(ctx, account2.ID())
+	require.NoError(t, err)
+	acc3, err := wlt.AccountByID(ctx, account3.ID())
+	require.NoError(t, err)
+	require.Nil(t, acc1.(*account).crypto)
+	require.Nil(t, acc2.(*account).crypto)
+
+	tests := []struct {
+		name       string
+		account    *account
+		passphrase string
+		err        error
+	}{
+		{
+			name:       "BatchIndividual",
+			account:    acc1.(*account),
+			passphrase: "passphrase 1",
+		},
+		{
+			name:       "BatchBatch",
+			account:    acc2.(*account),
+			passphrase: "batch passphrase",
+		},
+		{
+			name:       "BatchOtherIndividual",
+			account:    acc1.(*account),
+			passphrase: "passphrase 2",
+			err:        ErrIncorrectPassphrase,
+		},
+		{
+			name:       "BatchBad",
+			account:    acc2.(*account),
+			passphrase: "bad",
+			err:        ErrIncorrectPassphrase,
+		},
+		{
+			name:       "IndividualIndividual",
+			account:    acc3.(*account),
+			passphrase: "passphrase 3",
+		},
+		{
+			name:       "IndividualBatch",
+			account:    acc3.(*account),
+			passphrase: "batch passphrase",
+			err:        ErrIncorrectPassphrase,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, test.account.Lock(ctx))
+			err := test.account.Unlock(ctx, []byte(test.passphrase))
+			if test.err != nil {
+				require.ErrorIs(t, err, test.err)
+				unlocked, err := test.account.IsUnlocked(ctx)
+				require.NoError(t, err)
+				require.False(t, unlocked)
+			} else {
+				require.NoError(t, err)
+				sig, err := test.account.Sign(ctx, []byte("data"))
+				require.NoError(t, err)
+				require.True(t, sig.Verify([]byte("data"), test.account.PublicKey()))
+			}
+		})
+	}
+
+	// Accounts unlocked with either passphrase can be used together.
+	require.NoError(t, acc1.(*account).Lock(ctx))
+	require.NoError(t, acc2.(*account).Lock(ctx))
+	require.NoError(t, acc1.(*account).Unlock(ctx, []byte("passphrase 1")))
+	require.NoError(t, acc2.(*account).Unlock(ctx, []byte("batch passphrase")))
+	require.NoError(t, acc3.(*account).Unlock(ctx, []byte("passphrase 3")))
+	signatures, errs, err := wlt.SignMulti(ctx,
+		[]uuid.UUID{account1.ID(), account2.ID(), account3.ID()},
+		[][]byte{[]byte("data 1"), []byte("data 2"), []byte("data 3")},
+	)
+	require.NoError(t, err)
+	for i := range errs {
+		require.NoError(t, errs[i])
+		require.NotNil(t, signatures[i])
+	}
+}
+
+func TestBatchWalletWithOptions(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	wlt := w.(*wallet)
+	require.NoError(t, wlt.Unlock(ctx, nil))
+	for i := 0; i < 8; i++ {
+		_, err := wlt.CreateAccount(ctx, fmt.Sprintf("account %d", i), []byte("passphrase"))
+		require.NoError(t, err)
+	}
+
+	// Cancelled.
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, wlt.BatchWalletWithOptions(cancelledCtx, []string{"passphrase"}, "batch passphrase"), context.Canceled)
+	expiredCtx, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	require.ErrorIs(t, wlt.BatchWalletWithOptions(expiredCtx, []string{"passphrase"}, "batch passphrase"), context.DeadlineExceeded)
+	_, err = store.(e2wtypes.BatchRetriever).RetrieveBatch(ctx, wlt.ID())
+	require.Error(t, err)
+
+	// Bad passphrase.
+	require.ErrorIs(t, wlt.BatchWalletWithOptions(ctx, []string{"bad"}, "batch passphrase", WithBatchWorkers(2)), ErrIncorrectPassphrase)
+
+	// Good.
+	progress := make([]int, 0)
+	require.NoError(t, wlt.BatchWalletWithOptions(ctx, []string{"bad", "passphrase"}, "batch passphrase",
+		WithBatchWorkers(3),
+		WithBatchProgress(func(done int, total int) {
+			require.Equal(t, 8, total)
+			progress = append(progress, done)
+		}),
+	))
+	require.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8}, progress)
+
+	// Only new accounts are included in the progress of an update.
+	_, err = wlt.CreateAccount(ctx, "account 8", []byte("passphrase"))
+	require.NoError(t, err)
+	progress = progress[:0]
+	require.NoError(t, wlt.UpdateBatch(ctx, []string{"passphrase"}, "batch passphrase",
+		WithBatchProgress(func(done int, total int) {
+			require.Equal(t, 1, total)
+			progress = append(progress, done)
+		}),
+	))
+	require.Equal(t, []int{1}, progress)
+
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	status, err := w.(*wallet).BatchStatus(ctx)
+	require.NoError(t, err)
+	require.True(t, status.Current())
+	accounts := 0
+	for acc := range w.Accounts(ctx) {
+		require.NoError(t, acc.(e2wtypes.AccountLocker).Unlock(ctx, []byte("batch passphrase")))
+		accounts++
+	}
+	require.Equal(t, 9, accounts)
+}
+
+func TestBatchPassphraseResolver(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	wlt := w.(*wallet)
+	require.NoError(t, wlt.Unlock(ctx, nil))
+	accounts := make([]*account, 5)
+	for i := range accounts {
+		acc, err := wlt.CreateAccount(ctx, fmt.Sprintf("account %d", i), []byte(fmt.Sprintf("passphrase %d", i)))
+		require.NoError(t, err)
+		accounts[i] = acc.(*account)
+	}
+
+	pubkey := fmt.Sprintf("%#x", accounts[2].PublicKey().Marshal())
+	passphrases := map[string]string{
+		accounts[0].ID().String(): "passphrase 0",
+		"account 1":               "passphrase 1",
+		pubkey:                    "passphrase 2",
+		"account 4":               "bad",
+	}
+	calls := make(map[uuid.UUID]int)
+	var callsMutex sync.Mutex
+	resolver := func(id uuid.UUID, name string, pubkey []byte) (string, error) {
+		callsMutex.Lock()
+		calls[id]++
+		callsMutex.Unlock()
+
+		return PassphraseMap(passphrases)(id, name, pubkey)
+	}
+
+	err = wlt.BatchWalletWithOptions(ctx, nil, "batch passphrase", WithPassphraseResolver(resolver))
+	require.ErrorIs(t, err, ErrIncorrectPassphrase)
+	require.EqualError(t, err, `unable to decrypt accounts: "account 3": failed to obtain passphrase: no passphrase for account; "account 4": incorrect passphrase`)
+	for i := range accounts {
+		require.Equal(t, 1, calls[accounts[i].ID()])
+	}
+
+	passphrases["account 3"] = "passphrase 3"
+	passphrases["account 4"] = "passphrase 4"
+	require.NoError(t, wlt.BatchWalletWithOptions(ctx, nil, "batch passphrase", WithPassphraseResolver(PassphraseMap(passphrases))))
+
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	status, err := w.(*wallet).BatchStatus(ctx)
+	require.NoError(t, err)
+	require.True(t, status.Current())
+}
+
+func TestBatchFilters(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	wlt := w.(*wallet)
+	require.NoError(t, wlt.Unlock(ctx, nil))
+	accounts := make([]*account, 6)
+	for i := range accounts {
+		acc, err := wlt.CreateAccount(ctx, fmt.Sprintf("host %c %d", 'a'+i/3, i%3), []byte("passphrase"))
+		require.NoError(t, err)
+		accounts[i] = acc.(*account)
+	}
+
+	tests := []struct {
+		name     string
+		opts     []BatchOption
+		expected []int
+	}{
+		{
+			name:     "None",
+			expected: []int{0, 1, 2, 3, 4, 5},
+		},
+		{
+			name:     "Accounts",
+			opts:     []BatchOption{WithBatchAccounts(accounts[1].ID(), accounts[4].ID())},
+			expected: []int{1, 4},
+		},
+		{
+			name:     "NameMatch",
+			opts:     []BatchOption{WithBatchNameMatch(regexp.MustCompile("^host a "))},
+			expected: []int{0, 1, 2},
+		},
+		{
+			name:     "PublicKeys",
+			opts:     []BatchOption{WithBatchPublicKeys(accounts[5].PublicKey().Marshal())},
+			expected: []int{5},
+		},
+		{
+			name: "Multiple",
+			opts: []BatchOption{
+				WithBatchNameMatch(regexp.MustCompile("^host b ")),
+				WithBatchAccounts(accounts[0].ID()),
+			},
+			expected: []int{0, 3, 4, 5},
+		},
+		{
+			name:     "NoMatch",
+			opts:     []BatchOption{WithBatchNameMatch(regexp.MustCompile("^host c "))},
+			expected: []int{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, wlt.BatchWalletWithOptions(ctx, []string{"passphrase"}, "batch passphrase", test.opts...))
+
+			w, err := OpenWallet(ctx, "test wallet", store, encryptor)
+			require.NoError(t, err)
+			opened := w.(*wallet)
+			status, err := opened.BatchStatus(ctx)
+			require.NoError(t, err)
+			require.Len(t, status.Missing, len(accounts)-len(test.expected))
+			batched := make(map[uuid.UUID]bool)
+			for _, i := range test.expected {
+				batched[accounts[i].ID()] = true
+			}
+			require.Len(t, opened.batch.entries, len(test.expected))
+			for _, entry := range opened.batch.entries {
+				require.True(t, batched[entry.id])
+			}
+
+			// All accounts are available, with those outside the batch
+			// using their individual keystores.
+			found := 0
+			for acc := range opened.Accounts(ctx) {
+				passphrase := "passphrase"
+				if batched[acc.ID()] {
+					passphrase = "batch passphrase"
+				}
+				require.NoError(t, acc.(e2wtypes.AccountLocker).Unlock(ctx, []byte(passphrase)))
+				found++
+			}
+			require.Equal(t, len(accounts), found)
+		})
+	}
+
+	// Filters also apply to updates.
+	require.NoError(t, wlt.BatchWalletWithOptions(ctx, []string{"passphrase"}, "batch passphrase",
+		WithBatchNameMatch(regexp.MustCompile("^host a ")),
+	))
+	account, err := wlt.CreateAccount(ctx, "host a 3", []byte("passphrase"))
+	require.NoError(t, err)
+	require.NoError(t, wlt.UpdateBatch(ctx, []string{"passphrase"}, "batch passphrase",
+		WithBatchNameMatch(regexp.MustCompile("^host a ")),
+	))
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	status, err := w.(*wallet).BatchStatus(ctx)
+	require.NoError(t, err)
+	require.Len(t, w.(*wallet).batch.entries, 4)
+	require.Len(t, status.Missing, 3)
+	require.NotContains(t, status.Missing, account.ID())
+}
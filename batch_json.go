@@ -14,9 +14,11 @@
 package nd
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strings"
 
 	"github.com/google/uuid"
@@ -59,19 +61,45 @@ func (b *batchEntry) UnmarshalJSON(input []byte) error {
 	return nil
 }
 
+const (
+	// batchVersionJSON is the version of batches with entries held as JSON.
+	batchVersionJSON = 1
+	// batchVersionBinary is the version of batches with entries held in a
+	// compact binary form, which is faster to parse for large batches.
+	batchVersionBinary = 2
+)
+
 type batchJSON struct {
-	Entries   []*batchEntry  `json:"entries"`
-	Crypto    map[string]any `json:"crypto"`
-	Encryptor string         `json:"encryptor"`
+	Entries   []*batchEntry  `json:"entries,omitempty"`
+	EntryData []byte         `json:"entry_data,omitempty"`
+	Crypto    map[string]any `json:"crypto,omitempty"`
+	Encryptor string         `json:"encryptor,omitempty"`
 	Version   int            `json:"version"`
+	Removed   bool           `json:"removed,omitempty"`
 }
 
 func (b *batch) MarshalJSON() ([]byte, error) {
+	if b.removed {
+		res, err := json.Marshal(&batchJSON{
+			Version: batchVersionBinary,
+			Removed: true,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal JSON")
+		}
+
+		return res, nil
+	}
+
+	entryData, err := marshalBatchEntries(b.entries)
+	if err != nil {
+		return nil, err
+	}
 	res, err := json.Marshal(&batchJSON{
-		Entries:   b.entries,
+		EntryData: entryData,
 		Crypto:    b.crypto,
 		Encryptor: b.encryptor.String(),
-		Version:   version,
+		Version:   batchVersionBinary,
 	})
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to marshal JSON")
@@ -85,10 +113,23 @@ func (b *batch) UnmarshalJSON(input []byte) error {
 	if err := json.Unmarshal(input, &data); err != nil {
 		return errors.Wrap(err, "invalid JSON")
 	}
-	if data.Version != version {
+	if data.Removed {
+		b.removed = true
+
+		return nil
+	}
+	switch data.Version {
+	case batchVersionJSON:
+		b.entries = data.Entries
+	case batchVersionBinary:
+		var err error
+		b.entries, err = unmarshalBatchEntries(data.EntryData)
+		if err != nil {
+			return err
+		}
+	default:
 		return fmt.Errorf("unsupported version %d", data.Version)
 	}
-	b.entries = data.Entries
 	switch data.Encryptor {
 	case "keystorev4":
 		b.encryptor = keystorev4.New()
@@ -99,3 +140,91 @@ func (b *batch) UnmarshalJSON(input []byte) error {
 
 	return nil
 }
+
+// marshalBatchEntries encodes batch entries in binary form.  Each entry is
+// its 16-byte ID, followed by its public key and its name, each preceded by
+// a 2-byte big-endian length.
+func marshalBatchEntries(entries []*batchEntry) ([]byte, error) {
+	size := 0
+	for _, entry := range entries {
+		if len(entry.pubkey) > math.MaxUint16 || len(entry.name) > math.MaxUint16 {
+			return nil, fmt.Errorf("batch entry %s too large", entry.id)
+		}
+		size += 20 + len(entry.pubkey) + len(entry.name)
+	}
+
+	res := make([]byte, 0, size)
+	for _, entry := range entries {
+		res = append(res, entry.id[:]...)
+		res = binary.BigEndian.AppendUint16(res, uint16(len(entry.pubkey)))
+		res = append(res, entry.pubkey...)
+		res = binary.BigEndian.AppendUint16(res, uint16(len(entry.name)))
+		res = append(res, entry.name...)
+	}
+
+	return res, nil
+}
+
+// unmarshalBatchEntries decodes batch entries from binary form.
+func unmarshalBatchEntries(data []byte) ([]*batchEntry, error) {
+	entries := make([]*batchEntry, 0)
+	for len(data) > 0 {
+		entry := &batchEntry{}
+		if len(data) < 18 {
+			return nil, errors.New("batch entry data truncated")
+		}
+		copy(entry.id[:], data[:16])
+		pubkeyLen := int(binary.BigEndian.Uint16(data[16:18]))
+		data = data[18:]
+		if len(data) < pubkeyLen+2 {
+			return nil, errors.New("batch entry data truncated")
+		}
+		entry.pubkey = data[:pubkeyLen:pubkeyLen]
+		nameLen := int(binary.BigEndian.Uint16(data[pubkeyLen : pubkeyLen+2]))
+		data = data[pubkeyLen+2:]
+		if len(data) < nameLen {
+			return nil, errors.New("batch entry data truncated")
+		}
+		entry.name = string(data[:nameLen])
+		data = data[nameLen:]
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+type batchRecordJSON struct {
+	UUID   uuid.UUID       `json:"uuid"`
+	Record string          `json:"record"`
+	Name   string          `json:"name"`
+	Batch  json.RawMessage `json:"batch"`
+}
+
+func (r *batchRecord) MarshalJSON() ([]byte, error) {
+	res, err := json.Marshal(&batchRecordJSON{
+		UUID:   r.id,
+		Record: batchRecordType,
+		Name:   r.name,
+		Batch:  r.data,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal JSON")
+	}
+
+	return res, nil
+}
+
+func (r *batchRecord) UnmarshalJSON(input []byte) error {
+	data := batchRecordJSON{}
+	if err := json.Unmarshal(input, &data); err != nil {
+		return errors.Wrap(err, "invalid JSON")
+	}
+	if data.Record != batchRecordType {
+		return errors.New("not a batch record")
+	}
+	r.id = data.UUID
+	r.name = data.Name
+	r.data = data.Batch
+
+	return nil
+}
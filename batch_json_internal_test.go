@@ -0,0 +1,160 @@
+// Copyright © 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	keystorev4 "github.com/wealdtech/go-eth2-wallet-encryptor-keystorev4"
+	scratch "github.com/wealdtech/go-eth2-wallet-store-scratch"
+	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+func TestBatchJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		entries []*batchEntry
+		err     string
+	}{
+		{
+			name:  "Empty",
+			input: []byte(`{"version":2,"encryptor":"keystorev4","crypto":{}}`),
+		},
+		{
+			name:  "JSONEntries",
+			input: []byte(`{"version":1,"encryptor":"keystorev4","crypto":{},"entries":[{"uuid":"bf9c0a4b-d1a4-4bd7-8b0c-2b5e0e8b5a3e","name":"account 1","pubkey":"0x0102"}]}`),
+			entries: []*batchEntry{
+				{
+					id:     uuid.MustParse("bf9c0a4b-d1a4-4bd7-8b0c-2b5e0e8b5a3e"),
+					name:   "account 1",
+					pubkey: []byte{0x01, 0x02},
+				},
+			},
+		},
+		{
+			name:  "BinaryEntries",
+			input: []byte(`{"version":2,"encryptor":"keystorev4","crypto":{},"entry_data":"v5wKS9GkS9eLDCteDotaPgACAQIACWFjY291bnQgMQ=="}`),
+			entries: []*batchEntry{
+				{
+					id:     uuid.MustParse("bf9c0a4b-d1a4-4bd7-8b0c-2b5e0e8b5a3e"),
+					name:   "account 1",
+					pubkey: []byte{0x01, 0x02},
+				},
+			},
+		},
+		{
+			name:  "BinaryEntriesTruncated",
+			input: []byte(`{"version":2,"encryptor":"keystorev4","crypto":{},"entry_data":"v5wKS9GkS9eLDCteDotaPgACAQIACWFjY291bnQ="}`),
+			err:   "batch entry data truncated",
+		},
+		{
+			name:  "BinaryEntriesShort",
+			input: []byte(`{"version":2,"encryptor":"keystorev4","crypto":{},"entry_data":"v5wKS9Gk"}`),
+			err:   "batch entry data truncated",
+		},
+		{
+			name:  "VersionUnsupported",
+			input: []byte(`{"version":3,"encryptor":"keystorev4","crypto":{}}`),
+			err:   "unsupported version 3",
+		},
+		{
+			name:  "EncryptorUnsupported",
+			input: []byte(`{"version":2,"encryptor":"unknown","crypto":{}}`),
+			err:   "unsupported encryptor unknown",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := &batch{}
+			err := json.Unmarshal(test.input, res)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, res.entries, len(test.entries))
+			for i := range test.entries {
+				require.Equal(t, test.entries[i].id, res.entries[i].id)
+				require.Equal(t, test.entries[i].name, res.entries[i].name)
+				require.Equal(t, test.entries[i].pubkey, res.entries[i].pubkey)
+			}
+
+			// Batches are always written in binary form.
+			output, err := json.Marshal(res)
+			require.NoError(t, err)
+			data := make(map[string]any)
+			require.NoError(t, json.Unmarshal(output, &data))
+			require.Equal(t, float64(batchVersionBinary), data["version"])
+			require.NotContains(t, data, "entries")
+			rt := &batch{}
+			require.NoError(t, json.Unmarshal(output, rt))
+			require.Equal(t, res.entries, rt.entries)
+		})
+	}
+}
+
+func TestBatchJSONVersions(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(*wallet).Unlock(ctx, nil))
+	for _, name := range []string{"account 1", "account 2", "ακαουντ 3"} {
+		_, err := w.(*wallet).CreateAccount(ctx, name, []byte("passphrase"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.(*wallet).BatchWallet(ctx, []string{"passphrase"}, "batch passphrase"))
+
+	// Rewrite the batch with JSON entries, as written by earlier versions.
+	data, err := store.(e2wtypes.BatchRetriever).RetrieveBatch(ctx, w.ID())
+	require.NoError(t, err)
+	b := &batch{}
+	require.NoError(t, json.Unmarshal(data, b))
+	data, err = json.Marshal(&batchJSON{
+		Entries:   b.entries,
+		Crypto:    b.crypto,
+		Encryptor: b.encryptor.String(),
+		Version:   batchVersionJSON,
+	})
+	require.NoError(t, err)
+	require.NoError(t, store.(e2wtypes.BatchStorer).StoreBatch(ctx, w.ID(), w.Name(), data))
+
+	// Accounts can be obtained and unlocked from both versions.
+	for _, update := range []bool{false, true} {
+		if update {
+			require.NoError(t, w.(*wallet).UpdateBatch(ctx, nil, "batch passphrase"))
+			data, err := store.(e2wtypes.BatchRetriever).RetrieveBatch(ctx, w.ID())
+			require.NoError(t, err)
+			require.NotContains(t, string(data), `"entries"`)
+		}
+		opened, err := OpenWallet(ctx, "test wallet", store, encryptor)
+		require.NoError(t, err)
+		status, err := opened.(*wallet).BatchStatus(ctx)
+		require.NoError(t, err)
+		require.True(t, status.Current())
+		accounts := 0
+		for account := range opened.Accounts(ctx) {
+			require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("batch passphrase")))
+			accounts++
+		}
+		require.Equal(t, 3, accounts)
+	}
+}
@@ -74,7 +74,8 @@ func TestBatch(t *testing.T) {
 	for range wallet.Accounts(ctx) {
 		numAccounts++
 	}
-	require.Equal(t, 2, numAccounts)
+	// Accounts not in the batch are included.
+	require.Equal(t, 3, numAccounts)
 	obtainedAccount3, err := wallet.(e2wtypes.WalletAccountByNameProvider).AccountByName(ctx, "account 3")
 	require.NoError(t, err)
 	require.Equal(t, account3.ID(), obtainedAccount3.ID())
@@ -86,7 +87,8 @@ func TestBatch(t *testing.T) {
 	for range wallet.Accounts(ctx) {
 		numAccounts++
 	}
-	require.Equal(t, 2, numAccounts)
+	// Accounts not in the batch are included.
+	require.Equal(t, 3, numAccounts)
 	obtainedAccount3, err = wallet.(e2wtypes.WalletAccountByIDProvider).AccountByID(ctx, account3.ID())
 	require.NoError(t, err)
 	require.Equal(t, account3.Name(), obtainedAccount3.Name())
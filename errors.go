@@ -0,0 +1,77 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Errors returned by the wallet.  They are usually returned with additional
+// context, so should be checked with errors.Is() rather than compared directly.
+var (
+	// ErrWalletExists is returned when a wallet with the same name already exists.
+	ErrWalletExists = errors.New("wallet already exists")
+	// ErrWalletNotFound is returned when a wallet cannot be found.
+	ErrWalletNotFound = errors.New("wallet not found")
+	// ErrWalletLocked is returned when an operation requires an unlocked wallet.
+	ErrWalletLocked = errors.New("wallet is locked")
+	// ErrAccountExists is returned when an account with the same name already exists.
+	ErrAccountExists = errors.New("account already exists")
+	// ErrDuplicateKey is returned when importing a private key that is already in the wallet.
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrAccountNotFound is returned when an account cannot be found.
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrAccountLocked is returned when an operation requires an unlocked account,
+	// including when an account has been locked automatically due to a timeout.
+	ErrAccountLocked = errors.New("account is locked")
+	// ErrIncorrectPassphrase is returned when a passphrase fails to decrypt data.
+	ErrIncorrectPassphrase = errors.New("incorrect passphrase")
+	// ErrInvalidName is returned when an account name is missing or invalid.
+	ErrInvalidName = errors.New("invalid account name")
+	// ErrBatchStale is returned when a batch no longer reflects the accounts in the wallet.
+	ErrBatchStale = errors.New("batch is stale")
+	// ErrBatchTampered is returned when the entries of a batch do not match its encrypted data.
+	ErrBatchTampered = errors.New("batch tampered")
+	// ErrSlashable is returned when signing could result in the account being slashed.
+	ErrSlashable = errors.New("slashable")
+	// ErrAuditLogTampered is returned when an audit log fails verification.
+	ErrAuditLogTampered = errors.New("audit log tampered")
+)
+
+// walletError is an error with its own message that also identifies as one
+// of the errors above.
+type walletError struct {
+	msg string
+	err error
+}
+
+// newError creates an error with the given message that identifies as err.
+func newError(err error, format string, args ...any) error {
+	return &walletError{
+		msg: fmt.Sprintf(format, args...),
+		err: err,
+	}
+}
+
+// Error returns the message of the error.
+func (e *walletError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the underlying error.
+func (e *walletError) Unwrap() error {
+	return e.err
+}
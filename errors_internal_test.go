@@ -0,0 +1,179 @@
+// Copyright © 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	keystorev4 "github.com/wealdtech/go-eth2-wallet-encryptor-keystorev4"
+	scratch "github.com/wealdtech/go-eth2-wallet-store-scratch"
+	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	wlt := w.(*wallet)
+	require.NoError(t, wlt.Unlock(ctx, nil))
+	account, err := wlt.CreateAccount(ctx, "account", []byte("pass"))
+	require.NoError(t, err)
+	require.NoError(t, wlt.Lock(ctx))
+	unknownID := uuid.New()
+
+	pwWallet, err := CreateWallet(ctx, "passphrase wallet", store, encryptor, WithPassphrase([]byte("secret")))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		fn   func() error
+		err  error
+		msg  string
+	}{
+		{
+			name: "WalletExists",
+			fn: func() error {
+				_, err := CreateWallet(ctx, "test wallet", store, encryptor)
+				return err
+			},
+			err: ErrWalletExists,
+			msg: `wallet "test wallet" already exists`,
+		},
+		{
+			name: "WalletNotFound",
+			fn: func() error {
+				_, err := OpenWallet(ctx, "missing wallet", store, encryptor)
+				return err
+			},
+			err: ErrWalletNotFound,
+			msg: `wallet "missing wallet" does not exist: wallet not found`,
+		},
+		{
+			name: "WalletIncorrectPassphrase",
+			fn: func() error {
+				return pwWallet.(e2wtypes.WalletLocker).Unlock(ctx, []byte("wrong"))
+			},
+			err: ErrIncorrectPassphrase,
+			msg: "incorrect passphrase",
+		},
+		{
+			name: "WalletLocked",
+			fn: func() error {
+				_, err := wlt.CreateAccount(ctx, "another account", []byte("pass"))
+				return err
+			},
+			err: ErrWalletLocked,
+			msg: "wallet must be unlocked to create accounts",
+		},
+		{
+			name: "AccountNameMissing",
+			fn: func() error {
+				return wlt.RenameAccount(ctx, account.ID(), "")
+			},
+			err: ErrInvalidName,
+			msg: "account name missing",
+		},
+		{
+			name: "AccountNameInvalid",
+			fn: func() error {
+				return wlt.RenameAccount(ctx, account.ID(), "_bad")
+			},
+			err: ErrInvalidName,
+			msg: `invalid account name "_bad"`,
+		},
+		{
+			name: "AccountNotFound",
+			fn: func() error {
+				_, err := wlt.AccountByName(ctx, "missing")
+				return err
+			},
+			err: ErrAccountNotFound,
+			msg: `no account with name "missing"`,
+		},
+		{
+			name: "AccountIDNotFound",
+			fn: func() error {
+				_, err := wlt.AccountByID(ctx, unknownID)
+				return err
+			},
+			err: ErrAccountNotFound,
+		},
+		{
+			name: "AccountIncorrectPassphrase",
+			fn: func() error {
+				return account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("wrong"))
+			},
+			err: ErrIncorrectPassphrase,
+			msg: "incorrect passphrase",
+		},
+		{
+			name: "AccountLocked",
+			fn: func() error {
+				_, err := account.(e2wtypes.AccountSigner).Sign(ctx, []byte("data"))
+				return err
+			},
+			err: ErrAccountLocked,
+			msg: "cannot sign when account is locked",
+		},
+		{
+			name: "AccountPrivateKeyLocked",
+			fn: func() error {
+				_, err := account.(e2wtypes.AccountPrivateKeyProvider).PrivateKey(ctx)
+				return err
+			},
+			err: ErrAccountLocked,
+			msg: "cannot provide private key when account is locked",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.fn()
+			require.ErrorIs(t, err, test.err)
+			if test.msg != "" {
+				require.EqualError(t, err, test.msg)
+			}
+		})
+	}
+
+	// Wrapping retains the error.
+	err = errors.Wrap(newError(ErrAccountExists, "account exists"), "outer")
+	require.ErrorIs(t, err, ErrAccountExists)
+	require.EqualError(t, err, "outer: account exists")
+	require.EqualError(t, newError(ErrAccountNotFound, "no account with ID %s", unknownID), fmt.Sprintf("no account with ID %s", unknownID))
+
+	// Account exists and stale batch require an unlocked wallet.
+	require.NoError(t, wlt.Unlock(ctx, nil))
+	_, err = wlt.CreateAccount(ctx, "account", []byte("pass"))
+	require.ErrorIs(t, err, ErrAccountExists)
+	require.EqualError(t, err, `account with name "account" already exists`)
+
+	_, err = wlt.CreateAccount(ctx, "account2", []byte("pass"))
+	require.NoError(t, err)
+	require.NoError(t, wlt.BatchWallet(ctx, []string{"pass"}, "batch"))
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	reopened := w.(*wallet)
+	require.NoError(t, reopened.Unlock(ctx, nil))
+	require.NoError(t, reopened.DeleteAccount(ctx, account.ID()))
+	err = reopened.Rekey(ctx, []string{"pass", "batch"}, "new", nil)
+	require.ErrorIs(t, err, ErrBatchStale)
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// interchangeFormatVersion is the supported version of the EIP-3076 interchange format.
+const interchangeFormatVersion = "5"
+
+// ExportSlashingProtection exports the slashing protection data for the wallet
+// in EIP-3076 interchange format.  As only watermarks are held, the export
+// contains at most one block and one attestation for each public key.
+func (w *wallet) ExportSlashingProtection(_ context.Context, genesisValidatorsRoot []byte) ([]byte, error) {
+	if len(genesisValidatorsRoot) != 32 {
+		return nil, errors.New("genesis validators root must be 32 bytes")
+	}
+
+	w.protectMutex.Lock()
+	defer w.protectMutex.Unlock()
+
+	if err := w.loadProtection(); err != nil {
+		return nil, err
+	}
+
+	records := make([]*protectionRecord, 0, len(w.protection))
+	for _, record := range w.protection {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i int, j int) bool {
+		return bytes.Compare(records[i].pubkey, records[j].pubkey) < 0
+	})
+
+	interchange := &interchangeJSON{
+		Metadata: &interchangeMetadataJSON{
+			InterchangeFormatVersion: interchangeFormatVersion,
+			GenesisValidatorsRoot:    fmt.Sprintf("%#x", genesisValidatorsRoot),
+		},
+		Data: make([]*interchangeDataJSON, 0, len(records)),
+	}
+	for _, record := range records {
+		formatted := record.toJSON()
+		entry := &interchangeDataJSON{
+			Pubkey:             formatted.Pubkey,
+			SignedBlocks:       make([]*interchangeBlockJSON, 0, 1),
+			SignedAttestations: make([]*interchangeAttestationJSON, 0, 1),
+		}
+		if formatted.Block != nil {
+			entry.SignedBlocks = append(entry.SignedBlocks, formatted.Block)
+		}
+		if formatted.Attestation != nil {
+			entry.SignedAttestations = append(entry.SignedAttestations, formatted.Attestation)
+		}
+		interchange.Data = append(interchange.Data, entry)
+	}
+
+	res, err := json.Marshal(interchange)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal interchange data")
+	}
+
+	return res, nil
+}
+
+// ImportSlashingProtection imports slashing protection data in EIP-3076
+// interchange format.  Imported data is merged with existing data such that
+// watermarks are never lowered.  Data for public keys without an account in
+// the wallet is also imported, to protect accounts imported later.
+func (w *wallet) ImportSlashingProtection(_ context.Context, data []byte, genesisValidatorsRoot []byte) error {
+	interchange := &interchangeJSON{}
+	if err := json.Unmarshal(data, interchange); err != nil {
+		return errors.Wrap(err, "failed to unmarshal interchange data")
+	}
+	if interchange.Metadata == nil {
+		return errors.New("interchange metadata missing")
+	}
+	if interchange.Metadata.InterchangeFormatVersion != interchangeFormatVersion {
+		return fmt.Errorf("unsupported interchange format version %q", interchange.Metadata.InterchangeFormatVersion)
+	}
+	root, err := parseHex(interchange.Metadata.GenesisValidatorsRoot)
+	if err != nil {
+		return errors.Wrap(err, "invalid genesis validators root")
+	}
+	if !bytes.Equal(root, genesisValidatorsRoot) {
+		return fmt.Errorf("interchange data is for genesis validators root %#x", root)
+	}
+
+	w.protectMutex.Lock()
+	defer w.protectMutex.Unlock()
+
+	if err := w.loadProtection(); err != nil {
+		return err
+	}
+
+	// Merge all data before storing anything, so that invalid data is not
+	// partially imported.
+	updated := make(map[string]*protectionRecord)
+	for _, entry := range interchange.Data {
+		pubkey, err := parseHex(entry.Pubkey)
+		if err != nil {
+			return errors.Wrap(err, "invalid pubkey")
+		}
+		if len(pubkey) != 48 {
+			return fmt.Errorf("invalid pubkey length for %s", entry.Pubkey)
+		}
+		record, exists := updated[string(pubkey)]
+		if !exists {
+			record = w.protectionRecord(pubkey)
+		}
+		for _, block := range entry.SignedBlocks {
+			slot, signingRoot, err := parseInterchangeBlock(block)
+			if err != nil {
+				return errors.Wrapf(err, "invalid signed block for %s", entry.Pubkey)
+			}
+			record = record.withBlock(slot, signingRoot)
+		}
+		for _, attestation := range entry.SignedAttestations {
+			sourceEpoch, targetEpoch, signingRoot, err := parseInterchangeAttestation(attestation)
+			if err != nil {
+				return errors.Wrapf(err, "invalid signed attestation for %s", entry.Pubkey)
+			}
+			record = record.withAttestation(sourceEpoch, targetEpoch, signingRoot)
+		}
+		updated[string(pubkey)] = record
+	}
+
+	for pubkey, record := range updated {
+		if err := w.storeProtectionRecord(record); err != nil {
+			return err
+		}
+		w.protection[pubkey] = record
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	e2types "github.com/wealdtech/go-eth2-types/v2"
+)
+
+// SignMany signs multiple pieces of data with the account.
+// The lock state of the account is checked once, and the data is signed in
+// parallel.
+func (a *account) SignMany(ctx context.Context, data [][]byte) ([]e2types.Signature, error) {
+	signatures := make([]e2types.Signature, len(data))
+	if err := a.withSigningKey(func(key e2types.PrivateKey) error {
+		runParallel(len(data), func(i int) {
+			if ctx.Err() == nil {
+				signatures[i] = key.Sign(data[i])
+			}
+		})
+
+		return ctx.Err()
+	}); err != nil {
+		return nil, err
+	}
+	for i := range data {
+		if err := a.wallet.audit(ctx, AuditSign, a, data[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return signatures, nil
+}
+
+// SignMulti signs data with multiple accounts, where data[i] is signed by the
+// account with ID ids[i].  Accounts are signed for in parallel.
+// The returned signatures and errors match the order of the supplied data,
+// and a failure for one item does not stop the others from being signed.
+// An error is returned only if the request itself is invalid.
+func (w *wallet) SignMulti(ctx context.Context,
+	ids []uuid.UUID,
+	data [][]byte,
+) (
+	[]e2types.Signature,
+	[]error,
+	error,
+) {
+	if len(ids) != len(data) {
+		return nil, nil, errors.New("number of accounts and data do not match")
+	}
+
+	// Group the items by account, so that the lock state of each account is
+	// checked once.
+	groups := make(map[uuid.UUID][]int)
+	order := make([]uuid.UUID, 0)
+	for i, id := range ids {
+		if _, exists := groups[id]; !exists {
+			order = append(order, id)
+		}
+		groups[id] = append(groups[id], i)
+	}
+
+	signatures := make([]e2types.Signature, len(data))
+	errs := make([]error, len(data))
+	runParallel(len(order), func(i int) {
+		indices := groups[order[i]]
+		setErr := func(err error) {
+			for _, index := range indices {
+				errs[index] = err
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			setErr(err)
+			return
+		}
+		acc, err := w.AccountByID(ctx, order[i])
+		if err != nil {
+			setErr(err)
+			return
+		}
+		if err := acc.(*account).withSigningKey(func(key e2types.PrivateKey) error {
+			for _, index := range indices {
+				signatures[index] = key.Sign(data[index])
+			}
+
+			return nil
+		}); err != nil {
+			setErr(err)
+			return
+		}
+		for _, index := range indices {
+			if err := w.audit(ctx, AuditSign, acc.(*account), data[index]); err != nil {
+				signatures[index] = nil
+				errs[index] = err
+			}
+		}
+	})
+
+	return signatures, errs, nil
+}
+
+// runParallel calls the supplied function for each index from 0 to count-1,
+// using a number of workers bounded by the number of available CPUs.
+func runParallel(count int, fn func(i int)) {
+	runWorkers(count, runtime.GOMAXPROCS(0), fn)
+}
+
+// runWorkers calls the supplied function for each index from 0 to count-1,
+// using up to the given number of workers.
+func runWorkers(count int, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > count {
+		workers = count
+	}
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for j := 0; j < workers; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
@@ -0,0 +1,117 @@
+// Copyright © 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	keystorev4 "github.com/wealdtech/go-eth2-wallet-encryptor-keystorev4"
+	scratch "github.com/wealdtech/go-eth2-wallet-store-scratch"
+)
+
+func TestSignMany(t *testing.T) {
+	ctx := context.Background()
+	_, a := newProtectionTestAccount(ctx, t, scratch.New())
+
+	data := make([][]byte, 100)
+	for i := range data {
+		data[i] = []byte(fmt.Sprintf("data %d", i))
+	}
+
+	signatures, err := a.SignMany(ctx, data)
+	require.NoError(t, err)
+	require.Len(t, signatures, len(data))
+	for i := range data {
+		require.True(t, signatures[i].Verify(data[i], a.PublicKey()))
+	}
+
+	signatures, err = a.SignMany(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, signatures)
+
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = a.SignMany(cancelledCtx, data)
+	require.ErrorIs(t, err, context.Canceled)
+
+	require.NoError(t, a.Lock(ctx))
+	_, err = a.SignMany(ctx, data)
+	require.ErrorIs(t, err, ErrAccountLocked)
+}
+
+func TestSignMulti(t *testing.T) {
+	ctx := context.Background()
+	w, err := CreateWallet(ctx, "test wallet", scratch.New(), keystorev4.New())
+	require.NoError(t, err)
+	wallet := w.(*wallet)
+	require.NoError(t, wallet.Unlock(ctx, nil))
+
+	unlocked := make([]*account, 3)
+	for i := range unlocked {
+		acc, err := wallet.CreateAccount(ctx, fmt.Sprintf("unlocked %d", i), []byte("pass"))
+		require.NoError(t, err)
+		unlocked[i] = acc.(*account)
+		require.NoError(t, unlocked[i].Unlock(ctx, []byte("pass")))
+	}
+	locked, err := wallet.CreateAccount(ctx, "locked", []byte("pass"))
+	require.NoError(t, err)
+
+	_, _, err = wallet.SignMulti(ctx, []uuid.UUID{locked.ID()}, nil)
+	require.EqualError(t, err, "number of accounts and data do not match")
+
+	ids := []uuid.UUID{
+		unlocked[0].ID(),
+		locked.ID(),
+		unlocked[1].ID(),
+		uuid.New(),
+		unlocked[0].ID(),
+		unlocked[2].ID(),
+	}
+	data := make([][]byte, len(ids))
+	for i := range data {
+		data[i] = []byte(fmt.Sprintf("data %d", i))
+	}
+
+	signatures, errs, err := wallet.SignMulti(ctx, ids, data)
+	require.NoError(t, err)
+	require.Len(t, signatures, len(ids))
+	require.Len(t, errs, len(ids))
+	for i, id := range ids {
+		switch i {
+		case 1:
+			require.ErrorIs(t, errs[i], ErrAccountLocked)
+			require.Nil(t, signatures[i])
+		case 3:
+			require.ErrorIs(t, errs[i], ErrAccountNotFound)
+			require.Nil(t, signatures[i])
+		default:
+			require.NoError(t, errs[i])
+			acc, err := wallet.AccountByID(ctx, id)
+			require.NoError(t, err)
+			require.True(t, signatures[i].Verify(data[i], acc.(*account).PublicKey()))
+		}
+	}
+
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, errs, err = wallet.SignMulti(cancelledCtx, ids, data)
+	require.NoError(t, err)
+	for i := range errs {
+		require.ErrorIs(t, errs[i], context.Canceled)
+	}
+}
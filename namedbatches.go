@@ -0,0 +1,190 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+const batchRecordType = "batch"
+
+// NamedBatchStorer is the interface for stores that can hold multiple named
+// batches for a wallet.  Named batches in stores that do not implement it are
+// held as records alongside the wallet's accounts.
+type NamedBatchStorer interface {
+	// StoreNamedBatch stores the named batch for the given wallet.
+	StoreNamedBatch(ctx context.Context, walletID uuid.UUID, walletName string, batchName string, data []byte) error
+	// RetrieveNamedBatch retrieves the named batch for the given wallet.
+	RetrieveNamedBatch(ctx context.Context, walletID uuid.UUID, batchName string) ([]byte, error)
+	// ListNamedBatches lists the names of the batches for the given wallet.
+	ListNamedBatches(ctx context.Context, walletID uuid.UUID) ([]string, error)
+}
+
+// Batches lists the names of the batches available for the wallet, in
+// order.  The default batch, if present, is listed with an empty name.
+// Removed batches are not listed.
+func (w *wallet) Batches(ctx context.Context) ([]string, error) {
+	names := make([]string, 0)
+
+	if batchRetriever, isBatchRetriever := w.store.(e2wtypes.BatchRetriever); isBatchRetriever {
+		if data, err := batchRetriever.RetrieveBatch(ctx, w.id); err == nil && !isRemovedBatch(data) {
+			names = append(names, "")
+		}
+	}
+
+	if namedBatchStorer, isNamedBatchStorer := w.store.(NamedBatchStorer); isNamedBatchStorer {
+		batchNames, err := namedBatchStorer.ListNamedBatches(ctx, w.id)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list batches")
+		}
+		for _, name := range batchNames {
+			if data, err := namedBatchStorer.RetrieveNamedBatch(ctx, w.id, name); err == nil && !isRemovedBatch(data) {
+				names = append(names, name)
+			}
+		}
+	} else {
+		for data := range w.store.RetrieveAccounts(w.id) {
+			record := &batchRecord{}
+			if err := json.Unmarshal(data, record); err == nil && !isRemovedBatch(record.data) {
+				names = append(names, record.name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// canStoreBatches returns an error if the store cannot store the named
+// batch, or the default batch if the name is empty.
+func (w *wallet) canStoreBatches(name string) error {
+	if name != "" {
+		// Named batches can be held as records if required.
+		return nil
+	}
+	if _, isBatchStorer := w.store.(e2wtypes.BatchStorer); !isBatchStorer {
+		return fmt.Errorf("store %s cannot store batches", w.store.Name())
+	}
+
+	return nil
+}
+
+// canRetrieveBatches returns true if the store can retrieve the wallet's batch.
+func (w *wallet) canRetrieveBatches() bool {
+	_, isBatchRetriever := w.store.(e2wtypes.BatchRetriever)
+
+	return isBatchRetriever || w.batchName != ""
+}
+
+// storeBatchData stores the serialized named batch for the wallet, or the
+// default batch if the name is empty.
+func (w *wallet) storeBatchData(ctx context.Context, name string, data []byte) error {
+	if err := w.canStoreBatches(name); err != nil {
+		return err
+	}
+
+	var err error
+	switch {
+	case name == "":
+		err = w.store.(e2wtypes.BatchStorer).StoreBatch(ctx, w.id, w.name, data)
+	default:
+		if namedBatchStorer, isNamedBatchStorer := w.store.(NamedBatchStorer); isNamedBatchStorer {
+			err = namedBatchStorer.StoreNamedBatch(ctx, w.id, w.name, name, data)
+		} else {
+			var recordData []byte
+			recordData, err = json.Marshal(&batchRecord{
+				id:   batchRecordID(w.id, name),
+				name: name,
+				data: data,
+			})
+			if err != nil {
+				return errors.Wrap(err, "failed to marshal batch record")
+			}
+			err = w.store.StoreAccount(w.id, batchRecordID(w.id, name), recordData)
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to store batch")
+	}
+
+	return nil
+}
+
+// retrieveBatchData retrieves the serialized named batch for the wallet, or
+// the default batch if the name is empty.
+func (w *wallet) retrieveBatchData(ctx context.Context, name string) ([]byte, error) {
+	if name == "" {
+		batchRetriever, isBatchRetriever := w.store.(e2wtypes.BatchRetriever)
+		if !isBatchRetriever {
+			return nil, errors.New("not a batch retriever")
+		}
+
+		return batchRetriever.RetrieveBatch(ctx, w.id)
+	}
+
+	if namedBatchStorer, isNamedBatchStorer := w.store.(NamedBatchStorer); isNamedBatchStorer {
+		return namedBatchStorer.RetrieveNamedBatch(ctx, w.id, name)
+	}
+
+	data, err := w.store.RetrieveAccount(w.id, batchRecordID(w.id, name))
+	if err != nil {
+		return nil, err
+	}
+	record := &batchRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, errors.Wrap(err, "invalid batch record")
+	}
+
+	return record.data, nil
+}
+
+// batchRecord holds a named batch alongside the wallet's accounts, for
+// stores that cannot hold named batches themselves.
+type batchRecord struct {
+	id   uuid.UUID
+	name string
+	data []byte
+}
+
+// batchRecordID provides the ID under which a named batch record is stored.
+func batchRecordID(walletID uuid.UUID, name string) uuid.UUID {
+	return uuid.NewSHA1(walletID, []byte(fmt.Sprintf("%s %s", batchRecordType, name)))
+}
+
+// batchDescription describes a named batch, or the default batch if the name
+// is empty, for use in messages.
+func batchDescription(name string) string {
+	if name == "" {
+		return "default batch"
+	}
+
+	return fmt.Sprintf("batch %q", name)
+}
+
+// isRemovedBatch returns true if the serialized batch marks a removed batch.
+func isRemovedBatch(data []byte) bool {
+	res := &batch{}
+	if err := json.Unmarshal(data, res); err != nil {
+		return false
+	}
+
+	return res.removed
+}
@@ -0,0 +1,180 @@
+// Copyright © 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	keystorev4 "github.com/wealdtech/go-eth2-wallet-encryptor-keystorev4"
+	scratch "github.com/wealdtech/go-eth2-wallet-store-scratch"
+	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// namedBatchStore is a store that can hold named batches.
+type namedBatchStore struct {
+	*scratch.Store
+	mutex   sync.Mutex
+	batches map[string][]byte
+}
+
+func (s *namedBatchStore) StoreNamedBatch(_ context.Context, _ uuid.UUID, _ string, batchName string, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.batches[batchName] = data
+
+	return nil
+}
+
+func (s *namedBatchStore) RetrieveNamedBatch(_ context.Context, _ uuid.UUID, batchName string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, exists := s.batches[batchName]
+	if !exists {
+		return nil, errors.New("batch not found")
+	}
+
+	return data, nil
+}
+
+func (s *namedBatchStore) ListNamedBatches(_ context.Context, _ uuid.UUID) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	names := make([]string, 0, len(s.batches))
+	for name := range s.batches {
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// noBatchStore is a store that cannot hold batches.
+type noBatchStore struct {
+	e2wtypes.Store
+}
+
+func TestNamedBatches(t *testing.T) {
+	tests := []struct {
+		name         string
+		store        e2wtypes.Store
+		defaultBatch bool
+	}{
+		{
+			name:         "Records",
+			store:        scratch.New(),
+			defaultBatch: true,
+		},
+		{
+			name: "NamedBatchStorer",
+			store: &namedBatchStore{
+				Store:   scratch.New().(*scratch.Store),
+				batches: make(map[string][]byte),
+			},
+			defaultBatch: true,
+		},
+		{
+			name:  "NoBatchStorer",
+			store: &noBatchStore{Store: scratch.New()},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			encryptor := keystorev4.New()
+			w, err := CreateWallet(ctx, "test wallet", test.store, encryptor)
+			require.NoError(t, err)
+			require.NoError(t, w.(*wallet).Unlock(ctx, nil))
+			ids := make([]uuid.UUID, 4)
+			for i := range ids {
+				acc, err := w.(*wallet).CreateAccount(ctx, fmt.Sprintf("account %d", i), []byte("passphrase"))
+				require.NoError(t, err)
+				ids[i] = acc.ID()
+			}
+
+			names, err := w.(*wallet).Batches(ctx)
+			require.NoError(t, err)
+			require.Empty(t, names)
+
+			// Create the default batch and two named batches.
+			err = w.(*wallet).BatchWallet(ctx, []string{"passphrase"}, "batch 0")
+			if test.defaultBatch {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, "store scratch cannot store batches")
+			}
+			for i, name := range []string{"signer 1", "signer 2"} {
+				w, err := OpenWallet(ctx, "test wallet", test.store, encryptor, WithNamedBatch(name))
+				require.NoError(t, err)
+				require.NoError(t, w.(*wallet).BatchWalletWithOptions(ctx, []string{"passphrase"}, fmt.Sprintf("batch %d", i+1),
+					WithBatchAccounts(ids[2*i], ids[2*i+1]),
+				))
+			}
+
+			expected := []string{"signer 1", "signer 2"}
+			if test.defaultBatch {
+				expected = []string{"", "signer 1", "signer 2"}
+			}
+			names, err = w.(*wallet).Batches(ctx)
+			require.NoError(t, err)
+			require.Equal(t, expected, names)
+
+			// Each wallet uses its own batch.
+			for i, name := range []string{"signer 1", "signer 2"} {
+				w, err := OpenWallet(ctx, "test wallet", test.store, encryptor, WithNamedBatch(name))
+				require.NoError(t, err)
+				status, err := w.(*wallet).BatchStatus(ctx)
+				require.NoError(t, err)
+				require.True(t, status.Present)
+				require.Len(t, w.(*wallet).batch.entries, 2)
+				for j, id := range ids {
+					acc, err := w.(*wallet).AccountByID(ctx, id)
+					require.NoError(t, err)
+					passphrase := "passphrase"
+					if j/2 == i {
+						passphrase = fmt.Sprintf("batch %d", i+1)
+					}
+					require.NoError(t, acc.(*account).Unlock(ctx, []byte(passphrase)))
+				}
+			}
+			if test.defaultBatch {
+				w, err := OpenWallet(ctx, "test wallet", test.store, encryptor)
+				require.NoError(t, err)
+				acc, err := w.(*wallet).AccountByID(ctx, ids[3])
+				require.NoError(t, err)
+				require.NoError(t, acc.(*account).Unlock(ctx, []byte("batch 0")))
+			}
+
+			// Unknown batches are treated as absent.
+			w, err = OpenWallet(ctx, "test wallet", test.store, encryptor, WithNamedBatch("unknown"))
+			require.NoError(t, err)
+			status, err := w.(*wallet).BatchStatus(ctx)
+			require.NoError(t, err)
+			require.False(t, status.Present)
+
+			// Removing a named batch leaves the others.
+			w, err = OpenWallet(ctx, "test wallet", test.store, encryptor, WithNamedBatch("signer 2"))
+			require.NoError(t, err)
+			require.NoError(t, w.(*wallet).RemoveBatch(ctx))
+			names, err = w.(*wallet).Batches(ctx)
+			require.NoError(t, err)
+			require.Equal(t, expected[:len(expected)-1], names)
+		})
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// options are the options for the wallet.
+type options struct {
+	passphrase    []byte
+	allowDupKeys  bool
+	unlockTimeout time.Duration
+	idleTimeout   time.Duration
+	clock         func() time.Time
+	afterFunc     func(time.Duration, func()) Timer
+	auditSink     AuditSink
+	ignoreBatch   bool
+	batchName     string
+}
+
+// Option gives options to CreateWallet, OpenWallet and DeserializeWallet.
+type Option interface {
+	apply(*options)
+}
+
+type optionFunc func(*options)
+
+func (f optionFunc) apply(o *options) {
+	f(o)
+}
+
+// WithPassphrase sets the passphrase for a new wallet.
+// If set, the wallet can only be unlocked with this passphrase.
+// This is only used by CreateWallet.
+func WithPassphrase(passphrase []byte) Option {
+	return optionFunc(func(o *options) {
+		o.passphrase = passphrase
+	})
+}
+
+// WithAllowDuplicateKeys allows ImportAccount to import a private key that is
+// already in the wallet under another name.  Signing with the same key from
+// multiple accounts risks slashing, so this should only be used if the
+// duplicate accounts will never be active at the same time.
+func WithAllowDuplicateKeys() Option {
+	return optionFunc(func(o *options) {
+		o.allowDupKeys = true
+	})
+}
+
+// WithUnlockTimeout sets the maximum time that an account remains unlocked,
+// after which it is locked automatically.  0 means no limit.
+func WithUnlockTimeout(timeout time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.unlockTimeout = timeout
+	})
+}
+
+// WithIdleTimeout sets the maximum time that an account remains unlocked
+// without signing, after which it is locked automatically.  0 means no limit.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.idleTimeout = timeout
+	})
+}
+
+// WithClock sets the function used to obtain the current time.
+// Defaults to time.Now.
+func WithClock(clock func() time.Time) Option {
+	return optionFunc(func(o *options) {
+		o.clock = clock
+	})
+}
+
+// Timer is a timer that calls a function once it expires.
+type Timer interface {
+	// Stop prevents the timer from calling its function.
+	Stop() bool
+}
+
+// WithAfterFunc sets the function used to call a function once a duration
+// has passed, which schedules automatic locking of accounts.
+// Defaults to time.AfterFunc.  Used with WithClock, this allows time to be
+// controlled.
+func WithAfterFunc(afterFunc func(time.Duration, func()) Timer) Option {
+	return optionFunc(func(o *options) {
+		o.afterFunc = afterFunc
+	})
+}
+
+// WithAuditSink sets a sink to record unlocking, signing and account creation
+// and import.  NewStoreAuditSink provides a tamper-evident log in a store
+// separate from the wallet's store.
+func WithAuditSink(sink AuditSink) Option {
+	return optionFunc(func(o *options) {
+		o.auditSink = sink
+	})
+}
+
+// WithIgnoreBatch opens the wallet without using any batch, so that accounts
+// are always obtained and decrypted individually.  This can be used if the
+// batch passphrase has been lost or the batch is corrupt.
+func WithIgnoreBatch() Option {
+	return optionFunc(func(o *options) {
+		o.ignoreBatch = true
+	})
+}
+
+// WithNamedBatch selects the named batch used by the wallet, allowing
+// different signers to use different batches of the same wallet.  Batches
+// created, updated or removed through the wallet use this name.  If not set
+// the wallet uses its default batch.
+func WithNamedBatch(name string) Option {
+	return optionFunc(func(o *options) {
+		o.batchName = name
+	})
+}
+
+// afterFunc calls time.AfterFunc.
+func afterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// parseOptions parses the supplied options.
+func parseOptions(opts []Option) *options {
+	options := &options{
+		clock:     time.Now,
+		afterFunc: afterFunc,
+	}
+	for _, o := range opts {
+		o.apply(options)
+	}
+
+	return options
+}
+
+// batchOptions are the options for building batches.
+type batchOptions struct {
+	workers  int
+	progress func(done int, total int)
+	resolver PassphraseResolver
+	filters  []func(a *account) bool
+}
+
+// BatchOption gives options to BatchWalletWithOptions and UpdateBatch.
+type BatchOption interface {
+	applyBatch(*batchOptions)
+}
+
+type batchOptionFunc func(*batchOptions)
+
+func (f batchOptionFunc) applyBatch(o *batchOptions) {
+	f(o)
+}
+
+// WithBatchWorkers sets the number of accounts decrypted in parallel when
+// building a batch.  Defaults to the number of available CPUs.
+func WithBatchWorkers(workers int) BatchOption {
+	return batchOptionFunc(func(o *batchOptions) {
+		o.workers = workers
+	})
+}
+
+// WithBatchProgress sets a function that is called after each account is
+// decrypted when building a batch, with the number of accounts decrypted and
+// the total number to decrypt.  Calls are not made concurrently.
+func WithBatchProgress(progress func(done int, total int)) BatchOption {
+	return batchOptionFunc(func(o *batchOptions) {
+		o.progress = progress
+	})
+}
+
+// WithPassphraseResolver sets a resolver that provides the passphrase for each
+// account when building a batch, so that each account is decrypted only once.
+// The passphrases supplied to BatchWalletWithOptions and UpdateBatch are not
+// used, and if any accounts cannot be decrypted the error names all of them.
+// PassphraseMap provides a resolver from a map of passphrases.
+func WithPassphraseResolver(resolver PassphraseResolver) BatchOption {
+	return batchOptionFunc(func(o *batchOptions) {
+		o.resolver = resolver
+	})
+}
+
+// WithBatchAccounts includes the accounts with the given IDs in a batch.
+// If any filters are supplied a batch includes only the accounts that match
+// at least one of them; other accounts are decrypted individually.
+func WithBatchAccounts(ids ...uuid.UUID) BatchOption {
+	included := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		included[id] = true
+	}
+
+	return batchOptionFunc(func(o *batchOptions) {
+		o.filters = append(o.filters, func(a *account) bool {
+			return included[a.id]
+		})
+	})
+}
+
+// WithBatchNameMatch includes the accounts whose names match the given
+// regular expression in a batch.
+func WithBatchNameMatch(re *regexp.Regexp) BatchOption {
+	return batchOptionFunc(func(o *batchOptions) {
+		o.filters = append(o.filters, func(a *account) bool {
+			return re.MatchString(a.name)
+		})
+	})
+}
+
+// WithBatchPublicKeys includes the accounts with the given public keys in a
+// batch.
+func WithBatchPublicKeys(pubkeys ...[]byte) BatchOption {
+	included := make(map[string]bool, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		included[string(pubkey)] = true
+	}
+
+	return batchOptionFunc(func(o *batchOptions) {
+		o.filters = append(o.filters, func(a *account) bool {
+			return included[string(a.publicKey.Marshal())]
+		})
+	})
+}
+
+// parseBatchOptions parses the supplied batch options.
+func parseBatchOptions(opts []BatchOption) *batchOptions {
+	options := &batchOptions{
+		workers: runtime.GOMAXPROCS(0),
+	}
+	for _, o := range opts {
+		o.applyBatch(options)
+	}
+
+	return options
+}
+
+// includes returns true if the account should be included in a batch.
+func (o *batchOptions) includes(a *account) bool {
+	if len(o.filters) == 0 {
+		return true
+	}
+	for _, filter := range o.filters {
+		if filter(a) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// pubkeyIndex maps public keys to account IDs.
+// A public key can map to multiple accounts if duplicate keys are allowed, in
+// which case lookups provide the account that was added first.
+// It is complete once every account in the wallet has been added; until
+// then a lookup that misses may need to check the store.
+type pubkeyIndex struct {
+	mutex    sync.RWMutex
+	ids      map[string][]uuid.UUID
+	complete bool
+}
+
+// newPubkeyIndex creates a new public key index.
+func newPubkeyIndex() *pubkeyIndex {
+	return &pubkeyIndex{
+		ids: make(map[string][]uuid.UUID),
+	}
+}
+
+// Add adds a public key to the index.
+func (i *pubkeyIndex) Add(pubkey []byte, id uuid.UUID) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for _, existing := range i.ids[string(pubkey)] {
+		if existing == id {
+			return
+		}
+	}
+	i.ids[string(pubkey)] = append(i.ids[string(pubkey)], id)
+}
+
+// RemoveID removes the public key for an account from the index.
+// Other accounts with the same public key remain in the index.
+func (i *pubkeyIndex) RemoveID(id uuid.UUID) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for pubkey, ids := range i.ids {
+		remaining := make([]uuid.UUID, 0, len(ids))
+		for _, existing := range ids {
+			if existing != id {
+				remaining = append(remaining, existing)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(i.ids, pubkey)
+		} else {
+			i.ids[pubkey] = remaining
+		}
+	}
+}
+
+// ID provides the ID of the account with the given public key.
+func (i *pubkeyIndex) ID(pubkey []byte) (uuid.UUID, bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	ids, exists := i.ids[string(pubkey)]
+	if !exists {
+		return uuid.Nil, false
+	}
+
+	return ids[0], true
+}
+
+// Complete returns true if the index contains all accounts in the wallet.
+func (i *pubkeyIndex) Complete() bool {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	return i.complete
+}
+
+// SetComplete marks the index as containing all accounts in the wallet.
+func (i *pubkeyIndex) SetComplete() {
+	i.mutex.Lock()
+	i.complete = true
+	i.mutex.Unlock()
+}
+
+// AccountByPublicKey provides a single account from the wallet given its public key.
+// This will error if the account is not found.
+func (w *wallet) AccountByPublicKey(ctx context.Context, pubkey []byte) (e2wtypes.Account, error) {
+	// Loading the batch populates the index with the batched accounts.
+	_ = w.retrieveBatchIfRequired(ctx)
+
+	if id, exists := w.pubkeys.ID(pubkey); exists {
+		return w.AccountByID(ctx, id)
+	}
+
+	if !w.pubkeys.Complete() {
+		// Fall back to the accounts on the store.
+		w.indexStoredPublicKeys()
+		if id, exists := w.pubkeys.ID(pubkey); exists {
+			return w.AccountByID(ctx, id)
+		}
+	}
+
+	return nil, newError(ErrAccountNotFound, "no account with public key %#x", pubkey)
+}
+
+// indexStoredPublicKeys adds the public keys of all accounts on the store to
+// the public key index.
+func (w *wallet) indexStoredPublicKeys() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for data := range w.store.RetrieveAccounts(w.ID()) {
+		if account, err := deserializeAccount(w, data); err == nil && w.index.IDKnown(account.id) {
+			w.pubkeys.Add(account.publicKey.Marshal(), account.id)
+		}
+	}
+	w.pubkeys.SetComplete()
+}
@@ -0,0 +1,166 @@
+// Copyright © 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	e2types "github.com/wealdtech/go-eth2-types/v2"
+	keystorev4 "github.com/wealdtech/go-eth2-wallet-encryptor-keystorev4"
+	scratch "github.com/wealdtech/go-eth2-wallet-store-scratch"
+	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+func TestAccountByPublicKey(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	account1, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account1", []byte("pass"))
+	require.NoError(t, err)
+	key, err := e2types.GenerateBLSPrivateKey()
+	require.NoError(t, err)
+	unknownKey, err := e2types.GenerateBLSPrivateKey()
+	require.NoError(t, err)
+	account2, err := w.(e2wtypes.WalletAccountImporter).ImportAccount(ctx,
+		"account2",
+		key.Marshal(),
+		[]byte("pass"),
+	)
+	require.NoError(t, err)
+	pubkey1 := account1.(e2wtypes.AccountPublicKeyProvider).PublicKey().Marshal()
+	pubkey2 := account2.(e2wtypes.AccountPublicKeyProvider).PublicKey().Marshal()
+
+	tests := []struct {
+		name   string
+		pubkey []byte
+		id     string
+		err    error
+	}{
+		{
+			name:   "Nil",
+			pubkey: nil,
+			err:    ErrAccountNotFound,
+		},
+		{
+			name:   "Unknown",
+			pubkey: unknownKey.PublicKey().Marshal(),
+			err:    ErrAccountNotFound,
+		},
+		{
+			name:   "Created",
+			pubkey: pubkey1,
+			id:     account1.ID().String(),
+		},
+		{
+			name:   "Imported",
+			pubkey: pubkey2,
+			id:     account2.ID().String(),
+		},
+	}
+
+	// Run the tests against the original wallet, and against a re-opened
+	// wallet where the public keys need to be obtained from the store.
+	reopened, err := OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.False(t, reopened.(*wallet).pubkeys.Complete())
+	for _, wlt := range []e2wtypes.Wallet{w, reopened} {
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				account, err := wlt.(*wallet).AccountByPublicKey(ctx, test.pubkey)
+				if test.err != nil {
+					require.ErrorIs(t, err, test.err)
+				} else {
+					require.NoError(t, err)
+					require.Equal(t, test.id, account.ID().String())
+				}
+			})
+		}
+	}
+	require.True(t, reopened.(*wallet).pubkeys.Complete())
+
+	// Deleted accounts are no longer found.
+	require.NoError(t, w.(*wallet).DeleteAccount(ctx, account1.ID()))
+	_, err = w.(*wallet).AccountByPublicKey(ctx, pubkey1)
+	require.ErrorIs(t, err, ErrAccountNotFound)
+	reopened, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	_, err = reopened.(*wallet).AccountByPublicKey(ctx, pubkey1)
+	require.ErrorIs(t, err, ErrAccountNotFound)
+}
+
+func TestAccountByPublicKeyBatch(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	account, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account", []byte("pass"))
+	require.NoError(t, err)
+	require.NoError(t, w.(*wallet).BatchWallet(ctx, []string{"pass"}, "batch"))
+	pubkey := account.(e2wtypes.AccountPublicKeyProvider).PublicKey().Marshal()
+
+	reopened, err := OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	found, err := reopened.(*wallet).AccountByPublicKey(ctx, pubkey)
+	require.NoError(t, err)
+	require.Equal(t, account.ID(), found.ID())
+	// The account was found from the batch, so the store was not scanned.
+	require.False(t, reopened.(*wallet).pubkeys.Complete())
+}
+
+func TestAccountByPublicKeyDuplicates(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	privateKey, err := e2types.GenerateBLSPrivateKey()
+	require.NoError(t, err)
+	key := privateKey.Marshal()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor, WithAllowDuplicateKeys())
+	require.NoError(t, err)
+	wlt := w.(*wallet)
+	require.NoError(t, wlt.Unlock(ctx, nil))
+
+	account1, err := wlt.ImportAccount(ctx, "account1", key, []byte("pass"))
+	require.NoError(t, err)
+	account2, err := wlt.ImportAccount(ctx, "account2", key, []byte("pass"))
+	require.NoError(t, err)
+	pubkey := account1.(e2wtypes.AccountPublicKeyProvider).PublicKey().Marshal()
+
+	found, err := wlt.AccountByPublicKey(ctx, pubkey)
+	require.NoError(t, err)
+	require.Equal(t, account1.ID(), found.ID())
+
+	// Deleting one of the accounts leaves the other in the index.
+	require.NoError(t, wlt.DeleteAccount(ctx, account1.ID()))
+	found, err = wlt.AccountByPublicKey(ctx, pubkey)
+	require.NoError(t, err)
+	require.Equal(t, account2.ID(), found.ID())
+
+	// The remaining account is still seen as a duplicate.
+	wlt.allowDupKeys = false
+	_, err = wlt.ImportAccount(ctx, "account3", key, []byte("pass"))
+	require.ErrorIs(t, err, ErrDuplicateKey)
+
+	require.NoError(t, wlt.DeleteAccount(ctx, account2.ID()))
+	_, err = wlt.AccountByPublicKey(ctx, pubkey)
+	require.ErrorIs(t, err, ErrAccountNotFound)
+}
@@ -0,0 +1,207 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	e2types "github.com/wealdtech/go-eth2-types/v2"
+)
+
+// Rekey re-encrypts all accounts in the wallet, and all of its batches, with
+// a new passphrase.  Each account and batch is decrypted with the first of the
+// supplied passphrases that works.  Batches are obtained from the store, so
+// they are rekeyed even if the wallet was opened with WithIgnoreBatch or uses
+// a different named batch.
+//
+// Nothing is stored until every account and batch has been re-encrypted, and
+// if storing fails part way through the accounts and batches already stored
+// are returned to their original state.
+//
+// Rekey returns ErrBatchStale if a batch contains accounts that have since
+// been deleted; the batch should be recreated with BatchWallet first.
+//
+// If supplied, progress is called after each account has been re-encrypted.
+func (w *wallet) Rekey(ctx context.Context,
+	oldPassphrases []string,
+	newPassphrase string,
+	progress func(done int, total int),
+) error {
+	if !w.unlocked {
+		return newError(ErrWalletLocked, "wallet must be unlocked to rekey")
+	}
+
+	w.batchMutex.Lock()
+	defer w.batchMutex.Unlock()
+
+	// Obtain the batches directly from the store, as the wallet may have been
+	// opened without them.
+	batchNames, err := w.Batches(ctx)
+	if err != nil {
+		return err
+	}
+	batches := make([]*batch, len(batchNames))
+	originalBatches := make([][]byte, len(batchNames))
+	for i, name := range batchNames {
+		if originalBatches[i], err = w.retrieveBatchData(ctx, name); err != nil {
+			return errors.Wrapf(err, "failed to retrieve %s", batchDescription(name))
+		}
+		batches[i] = &batch{}
+		if err := json.Unmarshal(originalBatches[i], batches[i]); err != nil {
+			return errors.Wrapf(err, "failed to unmarshal %s", batchDescription(name))
+		}
+		status, err := w.compareBatch(batches[i])
+		if err != nil {
+			return err
+		}
+		if len(status.Extra) > 0 {
+			// Re-encrypting the batch would carry forward keys for deleted accounts.
+			return newError(ErrBatchStale, "%s is stale; recreate it before rekeying", batchDescription(name))
+		}
+	}
+
+	// Obtain individual accounts directly from store.
+	accounts := make([]*account, 0, 1024)
+	originals := make([][]byte, 0, 1024)
+	for data := range w.store.RetrieveAccounts(w.ID()) {
+		if account, err := deserializeAccount(w, data); err == nil {
+			accounts = append(accounts, account)
+			originals = append(originals, data)
+		}
+	}
+
+	// Re-encrypt the accounts.
+	rekeyed := make([][]byte, len(accounts))
+	for i, account := range accounts {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrap(err, "rekey cancelled")
+		}
+
+		var privateKey e2types.PrivateKey
+		var err error
+		for _, passphrase := range oldPassphrases {
+			if privateKey, err = account.decryptPrivateKey([]byte(passphrase)); err == nil {
+				break
+			}
+		}
+		if privateKey == nil {
+			return newError(ErrIncorrectPassphrase, "unable to decrypt account %q with supplied passphrases", account.name)
+		}
+
+		account.crypto, err = account.encryptor.Encrypt(privateKey.Marshal(), newPassphrase)
+		zeroizeKey(privateKey)
+		if err != nil {
+			return errors.Wrapf(err, "failed to encrypt account %q", account.name)
+		}
+		rekeyed[i], err = json.Marshal(account)
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal account %q", account.name)
+		}
+
+		if progress != nil {
+			progress(i+1, len(accounts))
+		}
+	}
+
+	// Re-encrypt the batches.  All batches must be re-encrypted, otherwise the
+	// old passphrases would continue to unlock accounts.
+	batchCryptos := make([]map[string]any, len(batches))
+	batchData := make([][]byte, len(batches))
+	undecryptable := make([]string, 0)
+	for i, existing := range batches {
+		var secretBytes []byte
+		var err error
+		for _, passphrase := range oldPassphrases {
+			if secretBytes, err = existing.encryptor.Decrypt(existing.crypto, passphrase); err == nil {
+				break
+			}
+		}
+		if secretBytes == nil {
+			undecryptable = append(undecryptable, batchDescription(batchNames[i]))
+			continue
+		}
+		if _, err := existing.secretKeys(secretBytes); err != nil {
+			zeroBytes(secretBytes)
+			return err
+		}
+		batchCryptos[i], err = w.encryptor.Encrypt(secretBytes, newPassphrase)
+		zeroBytes(secretBytes)
+		if err != nil {
+			return errors.Wrapf(err, "failed to encrypt %s", batchDescription(batchNames[i]))
+		}
+		batchData[i], err = json.Marshal(&batch{
+			entries:   existing.entries,
+			crypto:    batchCryptos[i],
+			encryptor: w.encryptor,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal %s", batchDescription(batchNames[i]))
+		}
+	}
+	if len(undecryptable) > 0 {
+		return newError(ErrIncorrectPassphrase, "unable to decrypt %s with supplied passphrases", strings.Join(undecryptable, ", "))
+	}
+
+	// Store everything, reverting on failure.
+	for i, account := range accounts {
+		if err := w.store.StoreAccount(w.id, account.id, rekeyed[i]); err != nil {
+			w.restoreAccounts(accounts[:i+1], originals)
+			return errors.Wrapf(err, "failed to store account %q", account.name)
+		}
+	}
+	for i, name := range batchNames {
+		if err := w.storeBatchData(ctx, name, batchData[i]); err != nil {
+			w.restoreAccounts(accounts, originals)
+			w.restoreBatches(ctx, batchNames[:i], originalBatches)
+			return err
+		}
+		if name == w.batchName && w.batch != nil && w.batch.crypto != nil {
+			w.batch.crypto = batchCryptos[i]
+		}
+	}
+	if len(batches) > 0 {
+		// Keys decrypted from the batch were obtained with an old passphrase.
+		w.clearBatchKeys()
+	}
+
+	// Update any accounts that have already been loaded.
+	for _, account := range accounts {
+		if loaded, exists := w.loadedAccount(account.id); exists && loaded.crypto != nil {
+			loaded.mutex.Lock()
+			loaded.crypto = account.crypto
+			loaded.mutex.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// restoreBatches stores the original data for a set of batches.
+// This is best effort, as it is only called when the store is already failing.
+func (w *wallet) restoreBatches(ctx context.Context, names []string, originals [][]byte) {
+	for i, name := range names {
+		_ = w.storeBatchData(ctx, name, originals[i])
+	}
+}
+
+// restoreAccounts stores the original data for a set of accounts.
+// This is best effort, as it is only called when the store is already failing.
+func (w *wallet) restoreAccounts(accounts []*account, originals [][]byte) {
+	for i, account := range accounts {
+		_ = w.store.StoreAccount(w.id, account.id, originals[i])
+	}
+}
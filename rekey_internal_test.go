@@ -0,0 +1,261 @@
+// Copyright © 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	keystorev4 "github.com/wealdtech/go-eth2-wallet-encryptor-keystorev4"
+	scratch "github.com/wealdtech/go-eth2-wallet-store-scratch"
+	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// failingStore is a store that fails a single account write after a given
+// number of writes.  A negative number of writes never fails.
+type failingStore struct {
+	e2wtypes.Store
+	writes int
+}
+
+func (s *failingStore) StoreAccount(walletID uuid.UUID, accountID uuid.UUID, data []byte) error {
+	if s.writes == 0 {
+		s.writes = -1
+		return errors.New("store failed")
+	}
+	if s.writes > 0 {
+		s.writes--
+	}
+
+	return s.Store.StoreAccount(walletID, accountID, data)
+}
+
+func (s *failingStore) StoreBatch(ctx context.Context, walletID uuid.UUID, walletName string, data []byte) error {
+	return s.Store.(e2wtypes.BatchStorer).StoreBatch(ctx, walletID, walletName, data)
+}
+
+func (s *failingStore) RetrieveBatch(ctx context.Context, walletID uuid.UUID) ([]byte, error) {
+	return s.Store.(e2wtypes.BatchRetriever).RetrieveBatch(ctx, walletID)
+}
+
+func TestRekey(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	account1, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account1", []byte("a"))
+	require.NoError(t, err)
+	account2, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account2", []byte("b"))
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletBatchCreator).BatchWallet(ctx, []string{"a", "b"}, "batch"))
+
+	// Re-open the wallet to pick up the batch.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+
+	// Rekeying requires an unlocked wallet.
+	require.EqualError(t, w.(*wallet).Rekey(ctx, []string{"a", "b", "batch"}, "new", nil), "wallet must be unlocked to rekey")
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	// Missing passphrase for an account; nothing should change.
+	require.EqualError(t, w.(*wallet).Rekey(ctx, []string{"a", "batch"}, "new", nil), `unable to decrypt account "account2" with supplied passphrases`)
+	// Missing passphrase for the batch; nothing should change.
+	require.EqualError(t, w.(*wallet).Rekey(ctx, []string{"a", "b"}, "new", nil), "unable to decrypt default batch with supplied passphrases")
+	for _, id := range []uuid.UUID{account1.ID(), account2.ID()} {
+		data, err := store.RetrieveAccount(w.ID(), id)
+		require.NoError(t, err)
+		stored, err := deserializeAccount(w.(*wallet), data)
+		require.NoError(t, err)
+		require.EqualError(t, stored.Unlock(ctx, []byte("new")), "incorrect passphrase")
+	}
+
+	progress := make([]int, 0)
+	require.NoError(t, w.(*wallet).Rekey(ctx, []string{"a", "b", "batch"}, "new", func(done int, total int) {
+		require.Equal(t, 2, total)
+		progress = append(progress, done)
+	}))
+	require.Equal(t, []int{1, 2}, progress)
+
+	// Individual accounts and the batch should all use the new passphrase.
+	for _, id := range []uuid.UUID{account1.ID(), account2.ID()} {
+		data, err := store.RetrieveAccount(w.ID(), id)
+		require.NoError(t, err)
+		stored, err := deserializeAccount(w.(*wallet), data)
+		require.NoError(t, err)
+		require.NoError(t, stored.Unlock(ctx, []byte("new")))
+	}
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	account, err := w.(e2wtypes.WalletAccountByIDProvider).AccountByID(ctx, account1.ID())
+	require.NoError(t, err)
+	require.Error(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("batch")))
+	require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("new")))
+}
+
+func TestRekeyDecryptedBatch(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	account1, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account1", []byte("a"))
+	require.NoError(t, err)
+	account2, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account2", []byte("a"))
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletBatchCreator).BatchWallet(ctx, []string{"a"}, "batch"))
+
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+	account, err := w.(e2wtypes.WalletAccountByIDProvider).AccountByID(ctx, account1.ID())
+	require.NoError(t, err)
+	require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("batch")))
+
+	require.NoError(t, w.(*wallet).Rekey(ctx, []string{"a", "batch"}, "new", nil))
+
+	// The old batch passphrase no longer unlocks accounts.
+	account, err = w.(e2wtypes.WalletAccountByIDProvider).AccountByID(ctx, account2.ID())
+	require.NoError(t, err)
+	require.ErrorIs(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("batch")), ErrIncorrectPassphrase)
+	require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("new")))
+}
+
+func TestRekeyIgnoreBatch(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	account1, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account1", []byte("a"))
+	require.NoError(t, err)
+	account2, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account2", []byte("a"))
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletBatchCreator).BatchWallet(ctx, []string{"a"}, "batch"))
+
+	// The stored batch is rekeyed even if the wallet was opened without it.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor, WithIgnoreBatch())
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+	require.EqualError(t, w.(*wallet).Rekey(ctx, []string{"a"}, "new", nil), "unable to decrypt default batch with supplied passphrases")
+	require.NoError(t, w.(*wallet).Rekey(ctx, []string{"a", "batch"}, "new", nil))
+
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	account, err := w.(e2wtypes.WalletAccountByIDProvider).AccountByID(ctx, account1.ID())
+	require.NoError(t, err)
+	require.Error(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("batch")))
+	require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("new")))
+
+	// A stale stored batch is refused.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor, WithIgnoreBatch())
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+	require.NoError(t, w.(*wallet).DeleteAccount(ctx, account2.ID()))
+	require.ErrorIs(t, w.(*wallet).Rekey(ctx, []string{"new"}, "newer", nil), ErrBatchStale)
+}
+
+func TestRekeyNamedBatches(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	ids := make([]uuid.UUID, 4)
+	for i := range ids {
+		account, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, fmt.Sprintf("account %d", i), []byte("a"))
+		require.NoError(t, err)
+		ids[i] = account.ID()
+	}
+	require.NoError(t, w.(*wallet).BatchWallet(ctx, []string{"a"}, "batch 0"))
+	batchNames := []string{"signer 1", "signer 2"}
+	for i, name := range batchNames {
+		w, err := OpenWallet(ctx, "test wallet", store, encryptor, WithNamedBatch(name))
+		require.NoError(t, err)
+		require.NoError(t, w.(*wallet).BatchWalletWithOptions(ctx, []string{"a"}, fmt.Sprintf("batch %d", i+1),
+			WithBatchAccounts(ids[2*i], ids[2*i+1]),
+		))
+	}
+
+	// Unlocks an account from the given batch.
+	unlock := func(batchName string, id uuid.UUID, passphrase string) error {
+		w, err := OpenWallet(ctx, "test wallet", store, encryptor, WithNamedBatch(batchName))
+		require.NoError(t, err)
+		account, err := w.(e2wtypes.WalletAccountByIDProvider).AccountByID(ctx, id)
+		require.NoError(t, err)
+
+		return account.(e2wtypes.AccountLocker).Unlock(ctx, []byte(passphrase))
+	}
+
+	// All batches are rekeyed regardless of the batch used by the wallet, so
+	// all of their passphrases are required.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor, WithNamedBatch("signer 1"))
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+	err = w.(*wallet).Rekey(ctx, []string{"a", "batch 1"}, "new", nil)
+	require.ErrorIs(t, err, ErrIncorrectPassphrase)
+	require.EqualError(t, err, `unable to decrypt default batch, batch "signer 2" with supplied passphrases`)
+	require.NoError(t, unlock("signer 1", ids[0], "batch 1"))
+
+	require.NoError(t, w.(*wallet).Rekey(ctx, []string{"a", "batch 0", "batch 1", "batch 2"}, "new", nil))
+	for i, batchName := range []string{"", "signer 1", "signer 2"} {
+		id := ids[0]
+		if batchName == "signer 2" {
+			id = ids[2]
+		}
+		require.Error(t, unlock(batchName, id, fmt.Sprintf("batch %d", i)))
+		require.NoError(t, unlock(batchName, id, "new"))
+	}
+}
+
+func TestRekeyRollback(t *testing.T) {
+	ctx := context.Background()
+	store := &failingStore{Store: scratch.New(), writes: -1}
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	ids := make([]uuid.UUID, 0)
+	for _, name := range []string{"account1", "account2", "account3"} {
+		account, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, name, []byte("old"))
+		require.NoError(t, err)
+		ids = append(ids, account.ID())
+	}
+
+	// Allow a single account to be stored before failing.
+	store.writes = 1
+	err = w.(*wallet).Rekey(ctx, []string{"old"}, "new", nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "store failed")
+	for _, id := range ids {
+		data, err := store.RetrieveAccount(w.ID(), id)
+		require.NoError(t, err)
+		stored, err := deserializeAccount(w.(*wallet), data)
+		require.NoError(t, err)
+		require.NoError(t, stored.Unlock(ctx, []byte("old")))
+	}
+}
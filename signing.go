@@ -0,0 +1,148 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	e2types "github.com/wealdtech/go-eth2-types/v2"
+)
+
+// SignGeneric signs a generic root with the given domain.
+// There are no slashing conditions for generic data, so it is not protected.
+func (a *account) SignGeneric(ctx context.Context, data []byte, domain []byte) (e2types.Signature, error) {
+	if err := checkRoots([]string{"data", "domain"}, data, domain); err != nil {
+		return nil, err
+	}
+
+	return a.Sign(ctx, signingRoot(data, domain))
+}
+
+// SignBeaconProposal signs a beacon block header with the given domain,
+// refusing if it could result in the account being slashed.
+func (a *account) SignBeaconProposal(ctx context.Context,
+	slot uint64,
+	proposerIndex uint64,
+	parentRoot []byte,
+	stateRoot []byte,
+	bodyRoot []byte,
+	domain []byte,
+) (
+	e2types.Signature,
+	error,
+) {
+	if err := checkRoots([]string{"parent root", "state root", "body root", "domain"},
+		parentRoot, stateRoot, bodyRoot, domain,
+	); err != nil {
+		return nil, err
+	}
+
+	// Hash tree root of BeaconBlockHeader.
+	root := merkleize(
+		uint64Leaf(slot),
+		uint64Leaf(proposerIndex),
+		parentRoot,
+		stateRoot,
+		bodyRoot,
+	)
+
+	return a.SignProposalRoot(ctx, slot, signingRoot(root, domain))
+}
+
+// SignBeaconAttestation signs beacon attestation data with the given domain,
+// refusing if it could result in the account being slashed.
+func (a *account) SignBeaconAttestation(ctx context.Context,
+	slot uint64,
+	committeeIndex uint64,
+	blockRoot []byte,
+	sourceEpoch uint64,
+	sourceRoot []byte,
+	targetEpoch uint64,
+	targetRoot []byte,
+	domain []byte,
+) (
+	e2types.Signature,
+	error,
+) {
+	if err := checkRoots([]string{"block root", "source root", "target root", "domain"},
+		blockRoot, sourceRoot, targetRoot, domain,
+	); err != nil {
+		return nil, err
+	}
+
+	// Hash tree root of AttestationData.
+	root := merkleize(
+		uint64Leaf(slot),
+		uint64Leaf(committeeIndex),
+		blockRoot,
+		merkleize(uint64Leaf(sourceEpoch), sourceRoot),
+		merkleize(uint64Leaf(targetEpoch), targetRoot),
+	)
+
+	return a.SignAttestationRoot(ctx, sourceEpoch, targetEpoch, signingRoot(root, domain))
+}
+
+// checkRoots ensures that each of the supplied roots is 32 bytes.
+func checkRoots(names []string, roots ...[]byte) error {
+	for i, root := range roots {
+		if len(root) != 32 {
+			return fmt.Errorf("%s must be 32 bytes", names[i])
+		}
+	}
+
+	return nil
+}
+
+// signingRoot provides the hash tree root of SigningData.
+func signingRoot(objectRoot []byte, domain []byte) []byte {
+	return merkleize(objectRoot, domain)
+}
+
+// uint64Leaf provides the SSZ leaf for a uint64.
+func uint64Leaf(val uint64) []byte {
+	leaf := make([]byte, 32)
+	binary.LittleEndian.PutUint64(leaf, val)
+
+	return leaf
+}
+
+// merkleize provides the SSZ merkle root of 32-byte leaves, padding the
+// leaves with zeros to the next power of two.
+func merkleize(leaves ...[]byte) []byte {
+	layer := make([][]byte, 1)
+	for len(layer) < len(leaves) {
+		layer = append(layer, layer...)
+	}
+	for i := range layer {
+		if i < len(leaves) {
+			layer[i] = leaves[i]
+		} else {
+			layer[i] = make([]byte, 32)
+		}
+	}
+
+	for len(layer) > 1 {
+		next := make([][]byte, len(layer)/2)
+		for i := range next {
+			hash := sha256.Sum256(append(append(make([]byte, 0, 64), layer[2*i]...), layer[2*i+1]...))
+			next[i] = hash[:]
+		}
+		layer = next
+	}
+
+	return layer[0]
+}
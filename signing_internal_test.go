@@ -0,0 +1,119 @@
+// Copyright © 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	scratch "github.com/wealdtech/go-eth2-wallet-store-scratch"
+)
+
+// hash hashes the concatenation of the supplied data.
+func hash(data ...[]byte) []byte {
+	res := sha256.Sum256(bytes.Join(data, nil))
+
+	return res[:]
+}
+
+func TestMerkleize(t *testing.T) {
+	zero := make([]byte, 32)
+
+	// Zero hashes for a tree of eight leaves.
+	expected, err := hex.DecodeString("c78009fdf07fc56a11f122370658a353aaa542ed63e44c4bc15ff4cd105ab33c")
+	require.NoError(t, err)
+	require.Equal(t, expected, merkleize(zero, zero, zero, zero, zero))
+
+	a := bytes.Repeat([]byte{0x01}, 32)
+	b := bytes.Repeat([]byte{0x02}, 32)
+	c := bytes.Repeat([]byte{0x03}, 32)
+	require.Equal(t, a, merkleize(a))
+	require.Equal(t, hash(a, b), merkleize(a, b))
+	require.Equal(t, hash(hash(a, b), hash(c, zero)), merkleize(a, b, c))
+	require.Equal(t, hash(a, b), signingRoot(a, b))
+
+	expected, err = hex.DecodeString("0807060504030201000000000000000000000000000000000000000000000000")
+	require.NoError(t, err)
+	require.Equal(t, expected, uint64Leaf(0x0102030405060708))
+}
+
+func TestSignBeaconProposal(t *testing.T) {
+	ctx := context.Background()
+	_, a := newProtectionTestAccount(ctx, t, scratch.New())
+
+	parentRoot := bytes.Repeat([]byte{0x01}, 32)
+	stateRoot := bytes.Repeat([]byte{0x02}, 32)
+	bodyRoot := bytes.Repeat([]byte{0x03}, 32)
+	domain := bytes.Repeat([]byte{0x04}, 32)
+
+	_, err := a.SignBeaconProposal(ctx, 10, 2, parentRoot[:31], stateRoot, bodyRoot, domain)
+	require.EqualError(t, err, "parent root must be 32 bytes")
+	_, err = a.SignBeaconProposal(ctx, 10, 2, parentRoot, stateRoot, bodyRoot, nil)
+	require.EqualError(t, err, "domain must be 32 bytes")
+
+	signature, err := a.SignBeaconProposal(ctx, 10, 2, parentRoot, stateRoot, bodyRoot, domain)
+	require.NoError(t, err)
+	headerRoot := hash(
+		hash(hash(uint64Leaf(10), uint64Leaf(2)), hash(parentRoot, stateRoot)),
+		merkleize(bodyRoot, make([]byte, 32), make([]byte, 32), make([]byte, 32)),
+	)
+	require.True(t, signature.Verify(hash(headerRoot, domain), a.PublicKey()))
+
+	// Signing the same proposal again is allowed.
+	_, err = a.SignBeaconProposal(ctx, 10, 2, parentRoot, stateRoot, bodyRoot, domain)
+	require.NoError(t, err)
+
+	// Signing a different proposal for the same slot is not.
+	_, err = a.SignBeaconProposal(ctx, 10, 2, parentRoot, stateRoot, parentRoot, domain)
+	require.ErrorIs(t, err, ErrSlashable)
+}
+
+func TestSignBeaconAttestation(t *testing.T) {
+	ctx := context.Background()
+	_, a := newProtectionTestAccount(ctx, t, scratch.New())
+
+	blockRoot := bytes.Repeat([]byte{0x01}, 32)
+	sourceRoot := bytes.Repeat([]byte{0x02}, 32)
+	targetRoot := bytes.Repeat([]byte{0x03}, 32)
+	domain := bytes.Repeat([]byte{0x04}, 32)
+
+	_, err := a.SignBeaconAttestation(ctx, 100, 1, blockRoot, 2, sourceRoot, 3, targetRoot[:1], domain)
+	require.EqualError(t, err, "target root must be 32 bytes")
+
+	signature, err := a.SignBeaconAttestation(ctx, 100, 1, blockRoot, 2, sourceRoot, 3, targetRoot, domain)
+	require.NoError(t, err)
+	zero := make([]byte, 32)
+	dataRoot := hash(
+		hash(hash(uint64Leaf(100), uint64Leaf(1)), hash(blockRoot, hash(uint64Leaf(2), sourceRoot))),
+		hash(hash(hash(uint64Leaf(3), targetRoot), zero), hash(zero, zero)),
+	)
+	require.True(t, signature.Verify(hash(dataRoot, domain), a.PublicKey()))
+
+	// Signing a different attestation for the same target is not allowed.
+	_, err = a.SignBeaconAttestation(ctx, 100, 2, blockRoot, 2, sourceRoot, 3, targetRoot, domain)
+	require.ErrorIs(t, err, ErrSlashable)
+
+	// Generic signing is not protected.
+	signature, err = a.SignGeneric(ctx, blockRoot, domain)
+	require.NoError(t, err)
+	require.True(t, signature.Verify(hash(blockRoot, domain), a.PublicKey()))
+	_, err = a.SignGeneric(ctx, blockRoot, domain)
+	require.NoError(t, err)
+	_, err = a.SignGeneric(ctx, []byte("data"), domain)
+	require.EqualError(t, err, "data must be 32 bytes")
+}
@@ -0,0 +1,268 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	e2types "github.com/wealdtech/go-eth2-types/v2"
+)
+
+const protectionRecordType = "slashing-protection"
+
+// protectionRecord holds the slashing protection watermarks for a public key.
+// Watermarks follow the minimal rules of EIP-3076: a block must be for a
+// higher slot than any previously signed block, and an attestation must have
+// a source epoch no lower and a target epoch higher than any previously
+// signed attestation.  Signing identical data again is allowed.
+type protectionRecord struct {
+	id     uuid.UUID
+	pubkey []byte
+
+	blockSigned bool
+	slot        uint64
+	blockRoot   []byte
+
+	attestationSigned bool
+	sourceEpoch       uint64
+	targetEpoch       uint64
+	attestationRoot   []byte
+}
+
+// protectionRecordID provides the ID under which the slashing protection
+// record for a public key is stored.  Records are held per public key rather
+// than per account, so accounts sharing a key share protection.
+func protectionRecordID(walletID uuid.UUID, pubkey []byte) uuid.UUID {
+	return uuid.NewSHA1(walletID, append([]byte(protectionRecordType), pubkey...))
+}
+
+// checkBlock checks if a block proposal can be signed.
+func (r *protectionRecord) checkBlock(slot uint64, signingRoot []byte) error {
+	if !r.blockSigned || slot > r.slot {
+		return nil
+	}
+	if slot == r.slot && r.blockRoot != nil && bytes.Equal(signingRoot, r.blockRoot) {
+		// Same block as previously signed.
+		return nil
+	}
+
+	return newError(ErrSlashable, "refusing to sign block at slot %d as already signed block at slot %d", slot, r.slot)
+}
+
+// checkAttestation checks if an attestation can be signed.
+func (r *protectionRecord) checkAttestation(sourceEpoch uint64, targetEpoch uint64, signingRoot []byte) error {
+	if sourceEpoch > targetEpoch {
+		return newError(ErrSlashable, "refusing to sign attestation with source epoch %d after target epoch %d", sourceEpoch, targetEpoch)
+	}
+	if !r.attestationSigned {
+		return nil
+	}
+	if sourceEpoch < r.sourceEpoch {
+		return newError(ErrSlashable, "refusing to sign attestation with source epoch %d as already signed source epoch %d", sourceEpoch, r.sourceEpoch)
+	}
+	if targetEpoch > r.targetEpoch {
+		return nil
+	}
+	if targetEpoch == r.targetEpoch && r.attestationRoot != nil && bytes.Equal(signingRoot, r.attestationRoot) {
+		// Same attestation as previously signed.
+		return nil
+	}
+
+	return newError(ErrSlashable, "refusing to sign attestation with target epoch %d as already signed target epoch %d", targetEpoch, r.targetEpoch)
+}
+
+// withBlock provides a copy of the record updated with a signed block.
+// If the block is for the same slot as the watermark but does not have the
+// same signing root then the root is cleared, so that no block can be signed
+// at that slot.
+func (r *protectionRecord) withBlock(slot uint64, signingRoot []byte) *protectionRecord {
+	res := *r
+	switch {
+	case !res.blockSigned || slot > res.slot:
+		res.blockSigned = true
+		res.slot = slot
+		res.blockRoot = signingRoot
+	case slot == res.slot && !bytes.Equal(signingRoot, res.blockRoot):
+		res.blockRoot = nil
+	}
+
+	return &res
+}
+
+// withAttestation provides a copy of the record updated with a signed attestation.
+// If the attestation is for the same target epoch as the watermark but does
+// not have the same signing root then the root is cleared, so that no
+// attestation can be signed for that target epoch.
+func (r *protectionRecord) withAttestation(sourceEpoch uint64, targetEpoch uint64, signingRoot []byte) *protectionRecord {
+	res := *r
+	if !res.attestationSigned || sourceEpoch > res.sourceEpoch {
+		res.sourceEpoch = sourceEpoch
+	}
+	switch {
+	case !res.attestationSigned || targetEpoch > res.targetEpoch:
+		res.targetEpoch = targetEpoch
+		res.attestationRoot = signingRoot
+	case targetEpoch == res.targetEpoch && !bytes.Equal(signingRoot, res.attestationRoot):
+		res.attestationRoot = nil
+	}
+	res.attestationSigned = true
+
+	return &res
+}
+
+// SignProposalRoot signs the signing root of a beacon block proposal for the
+// given slot, refusing if it could result in the account being slashed.
+func (a *account) SignProposalRoot(ctx context.Context, slot uint64, signingRoot []byte) (e2types.Signature, error) {
+	return a.signProtected(ctx, signingRoot, func(r *protectionRecord) (*protectionRecord, error) {
+		if err := r.checkBlock(slot, signingRoot); err != nil {
+			return nil, err
+		}
+
+		return r.withBlock(slot, signingRoot), nil
+	})
+}
+
+// SignAttestationRoot signs the signing root of a beacon attestation for the
+// given source and target epochs, refusing if it could result in the account
+// being slashed.
+func (a *account) SignAttestationRoot(ctx context.Context,
+	sourceEpoch uint64,
+	targetEpoch uint64,
+	signingRoot []byte,
+) (
+	e2types.Signature,
+	error,
+) {
+	return a.signProtected(ctx, signingRoot, func(r *protectionRecord) (*protectionRecord, error) {
+		if err := r.checkAttestation(sourceEpoch, targetEpoch, signingRoot); err != nil {
+			return nil, err
+		}
+
+		return r.withAttestation(sourceEpoch, targetEpoch, signingRoot), nil
+	})
+}
+
+// signProtected signs data once the slashing protection record for the
+// account has been checked and updated by the supplied function.
+// The updated record is stored before signing, so a failure to store it
+// results in no signature.
+func (a *account) signProtected(ctx context.Context,
+	signingRoot []byte,
+	update func(*protectionRecord) (*protectionRecord, error),
+) (
+	e2types.Signature,
+	error,
+) {
+	var signature e2types.Signature
+	if err := a.withSigningKey(func(key e2types.PrivateKey) error {
+		w := a.wallet
+		w.protectMutex.Lock()
+		defer w.protectMutex.Unlock()
+
+		if err := w.loadProtection(); err != nil {
+			return err
+		}
+		pubkey := a.publicKey.Marshal()
+		updated, err := update(w.protectionRecord(pubkey))
+		if err != nil {
+			return err
+		}
+		if err := w.storeProtectionRecord(updated); err != nil {
+			return err
+		}
+		w.protection[string(pubkey)] = updated
+
+		signature = key.Sign(signingRoot)
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := a.wallet.audit(ctx, AuditSign, a, signingRoot); err != nil {
+		return nil, err
+	}
+
+	return signature, nil
+}
+
+// loadProtection loads the slashing protection records from the store, if
+// not already loaded.
+// This must be called with the protection mutex held.
+func (w *wallet) loadProtection() error {
+	if w.protection != nil {
+		return nil
+	}
+
+	protection := make(map[string]*protectionRecord)
+	var err error
+	for data := range w.store.RetrieveAccounts(w.id) {
+		// Continue on error, to drain the channel.
+		if err != nil || !isProtectionRecord(data) {
+			continue
+		}
+		record := &protectionRecord{}
+		if err = json.Unmarshal(data, record); err == nil {
+			protection[string(record.pubkey)] = record
+		}
+	}
+	if err != nil {
+		// Refuse to sign rather than risk using incomplete records.
+		return errors.Wrap(err, "failed to unmarshal slashing protection record")
+	}
+	w.protection = protection
+
+	return nil
+}
+
+// protectionRecord provides the slashing protection record for a public key.
+// This must be called with the protection mutex held, after loadProtection.
+func (w *wallet) protectionRecord(pubkey []byte) *protectionRecord {
+	if record, exists := w.protection[string(pubkey)]; exists {
+		return record
+	}
+
+	return &protectionRecord{
+		id:     protectionRecordID(w.id, pubkey),
+		pubkey: pubkey,
+	}
+}
+
+// storeProtectionRecord stores a slashing protection record.
+func (w *wallet) storeProtectionRecord(record *protectionRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal slashing protection record")
+	}
+	if err := w.store.StoreAccount(w.id, record.id, data); err != nil {
+		return errors.Wrap(err, "failed to store slashing protection record")
+	}
+
+	return nil
+}
+
+// isProtectionRecord returns true if the data is a slashing protection record.
+func isProtectionRecord(data []byte) bool {
+	header := struct {
+		Record string `json:"record"`
+	}{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return false
+	}
+
+	return header.Record == protectionRecordType
+}
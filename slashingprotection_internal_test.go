@@ -0,0 +1,373 @@
+// Copyright © 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	keystorev4 "github.com/wealdtech/go-eth2-wallet-encryptor-keystorev4"
+	scratch "github.com/wealdtech/go-eth2-wallet-store-scratch"
+	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// newProtectionTestAccount creates a wallet with a single unlocked account.
+func newProtectionTestAccount(ctx context.Context, t *testing.T, store e2wtypes.Store) (*wallet, *account) {
+	t.Helper()
+
+	w, err := CreateWallet(ctx, "test wallet", store, keystorev4.New())
+	require.NoError(t, err)
+	require.NoError(t, w.(*wallet).Unlock(ctx, nil))
+	a, err := w.(*wallet).CreateAccount(ctx, "account", []byte("pass"))
+	require.NoError(t, err)
+	require.NoError(t, a.(*account).Unlock(ctx, []byte("pass")))
+
+	return w.(*wallet), a.(*account)
+}
+
+func TestSignProposalRoot(t *testing.T) {
+	ctx := context.Background()
+	_, a := newProtectionTestAccount(ctx, t, scratch.New())
+
+	root1 := bytes.Repeat([]byte{0x01}, 32)
+	root2 := bytes.Repeat([]byte{0x02}, 32)
+
+	tests := []struct {
+		name string
+		slot uint64
+		root []byte
+		err  string
+	}{
+		{
+			name: "First",
+			slot: 10,
+			root: root1,
+		},
+		{
+			name: "Repeat",
+			slot: 10,
+			root: root1,
+		},
+		{
+			name: "Double",
+			slot: 10,
+			root: root2,
+			err:  "refusing to sign block at slot 10 as already signed block at slot 10",
+		},
+		{
+			name: "Lower",
+			slot: 9,
+			root: root2,
+			err:  "refusing to sign block at slot 9 as already signed block at slot 10",
+		},
+		{
+			name: "Higher",
+			slot: 11,
+			root: root2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signature, err := a.SignProposalRoot(ctx, test.slot, test.root)
+			if test.err != "" {
+				require.ErrorIs(t, err, ErrSlashable)
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+				require.True(t, signature.Verify(test.root, a.PublicKey()))
+			}
+		})
+	}
+}
+
+func TestSignAttestationRoot(t *testing.T) {
+	ctx := context.Background()
+	_, a := newProtectionTestAccount(ctx, t, scratch.New())
+
+	root1 := bytes.Repeat([]byte{0x01}, 32)
+	root2 := bytes.Repeat([]byte{0x02}, 32)
+
+	tests := []struct {
+		name   string
+		source uint64
+		target uint64
+		root   []byte
+		err    string
+	}{
+		{
+			name:   "SourceAfterTarget",
+			source: 3,
+			target: 2,
+			root:   root1,
+			err:    "refusing to sign attestation with source epoch 3 after target epoch 2",
+		},
+		{
+			name:   "First",
+			source: 2,
+			target: 3,
+			root:   root1,
+		},
+		{
+			name:   "Repeat",
+			source: 2,
+			target: 3,
+			root:   root1,
+		},
+		{
+			name:   "Double",
+			source: 2,
+			target: 3,
+			root:   root2,
+			err:    "refusing to sign attestation with target epoch 3 as already signed target epoch 3",
+		},
+		{
+			name:   "Surrounding",
+			source: 1,
+			target: 4,
+			root:   root2,
+			err:    "refusing to sign attestation with source epoch 1 as already signed source epoch 2",
+		},
+		{
+			name:   "Surrounded",
+			source: 2,
+			target: 2,
+			root:   root2,
+			err:    "refusing to sign attestation with target epoch 2 as already signed target epoch 3",
+		},
+		{
+			name:   "Next",
+			source: 3,
+			target: 4,
+			root:   root2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signature, err := a.SignAttestationRoot(ctx, test.source, test.target, test.root)
+			if test.err != "" {
+				require.ErrorIs(t, err, ErrSlashable)
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+				require.True(t, signature.Verify(test.root, a.PublicKey()))
+			}
+		})
+	}
+}
+
+func TestSlashingProtectionPersisted(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	w, a := newProtectionTestAccount(ctx, t, store)
+	root := bytes.Repeat([]byte{0x01}, 32)
+
+	// Locked accounts do not sign or update the watermarks.
+	require.NoError(t, a.Lock(ctx))
+	_, err := a.SignProposalRoot(ctx, 5, root)
+	require.ErrorIs(t, err, ErrAccountLocked)
+	require.NoError(t, a.Unlock(ctx, []byte("pass")))
+
+	_, err = a.SignProposalRoot(ctx, 10, root)
+	require.NoError(t, err)
+	_, err = a.SignAttestationRoot(ctx, 2, 3, root)
+	require.NoError(t, err)
+
+	// Watermarks survive re-opening the wallet.
+	reopened, err := OpenWallet(ctx, "test wallet", store, keystorev4.New())
+	require.NoError(t, err)
+	acc, err := reopened.(*wallet).AccountByID(ctx, a.ID())
+	require.NoError(t, err)
+	require.NoError(t, acc.(*account).Unlock(ctx, []byte("pass")))
+	_, err = acc.(*account).SignProposalRoot(ctx, 9, root)
+	require.ErrorIs(t, err, ErrSlashable)
+	_, err = acc.(*account).SignAttestationRoot(ctx, 2, 3, bytes.Repeat([]byte{0x02}, 32))
+	require.ErrorIs(t, err, ErrSlashable)
+
+	// Records are not accounts.
+	accounts := 0
+	for range reopened.Accounts(ctx) {
+		accounts++
+	}
+	require.Equal(t, 1, accounts)
+	_, err = w.Export(ctx, []byte("export"))
+	require.NoError(t, err)
+}
+
+func TestSlashingProtectionInterchange(t *testing.T) {
+	ctx := context.Background()
+	w, a := newProtectionTestAccount(ctx, t, scratch.New())
+	gvr := bytes.Repeat([]byte{0x04}, 32)
+	root := bytes.Repeat([]byte{0x01}, 32)
+	pubkey := fmt.Sprintf("%#x", a.PublicKey().Marshal())
+	otherPubkey := fmt.Sprintf("%#x", bytes.Repeat([]byte{0x05}, 48))
+
+	_, err := a.SignProposalRoot(ctx, 10, root)
+	require.NoError(t, err)
+	_, err = a.SignAttestationRoot(ctx, 2, 3, root)
+	require.NoError(t, err)
+
+	_, err = w.ExportSlashingProtection(ctx, []byte{0x01})
+	require.EqualError(t, err, "genesis validators root must be 32 bytes")
+	exported, err := w.ExportSlashingProtection(ctx, gvr)
+	require.NoError(t, err)
+	require.JSONEq(t, fmt.Sprintf(`{"metadata":{"interchange_format_version":"5","genesis_validators_root":"%#x"},"data":[{"pubkey":"%s","signed_blocks":[{"slot":"10","signing_root":"%#x"}],"signed_attestations":[{"source_epoch":"2","target_epoch":"3","signing_root":"%#x"}]}]}`, gvr, pubkey, root, root), string(exported))
+
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{
+			name: "Invalid",
+			data: `bad`,
+			err:  "failed to unmarshal interchange data: invalid character 'b' looking for beginning of value",
+		},
+		{
+			name: "MetadataMissing",
+			data: `{"data":[]}`,
+			err:  "interchange metadata missing",
+		},
+		{
+			name: "VersionUnsupported",
+			data: fmt.Sprintf(`{"metadata":{"interchange_format_version":"4","genesis_validators_root":"%#x"},"data":[]}`, gvr),
+			err:  `unsupported interchange format version "4"`,
+		},
+		{
+			name: "GenesisValidatorsRootMismatch",
+			data: fmt.Sprintf(`{"metadata":{"interchange_format_version":"5","genesis_validators_root":"%#x"},"data":[]}`, root),
+			err:  fmt.Sprintf("interchange data is for genesis validators root %#x", root),
+		},
+		{
+			name: "PubkeyInvalid",
+			data: fmt.Sprintf(`{"metadata":{"interchange_format_version":"5","genesis_validators_root":"%#x"},"data":[{"pubkey":"0x01","signed_blocks":[],"signed_attestations":[]}]}`, gvr),
+			err:  "invalid pubkey length for 0x01",
+		},
+		{
+			name: "SlotInvalid",
+			data: fmt.Sprintf(`{"metadata":{"interchange_format_version":"5","genesis_validators_root":"%#x"},"data":[{"pubkey":"%s","signed_blocks":[{"slot":"bad"}],"signed_attestations":[]}]}`, gvr, otherPubkey),
+			err:  fmt.Sprintf(`invalid signed block for %s: invalid slot: strconv.ParseUint: parsing "bad": invalid syntax`, otherPubkey),
+		},
+		{
+			name: "AttestationInvalid",
+			data: fmt.Sprintf(`{"metadata":{"interchange_format_version":"5","genesis_validators_root":"%#x"},"data":[{"pubkey":"%s","signed_blocks":[],"signed_attestations":[{"source_epoch":"3","target_epoch":"2"}]}]}`, gvr, otherPubkey),
+			err:  fmt.Sprintf("invalid signed attestation for %s: source epoch 3 after target epoch 2", otherPubkey),
+		},
+		{
+			name: "Lower",
+			data: fmt.Sprintf(`{"metadata":{"interchange_format_version":"5","genesis_validators_root":"%#x"},"data":[{"pubkey":"%s","signed_blocks":[{"slot":"5"}],"signed_attestations":[{"source_epoch":"0","target_epoch":"1"}]}]}`, gvr, pubkey),
+		},
+		{
+			name: "Higher",
+			data: fmt.Sprintf(`{"metadata":{"interchange_format_version":"5","genesis_validators_root":"%#x"},"data":[{"pubkey":"%s","signed_blocks":[{"slot":"20"}],"signed_attestations":[{"source_epoch":"4","target_epoch":"5"}]},{"pubkey":"%s","signed_blocks":[{"slot":"1"}],"signed_attestations":[]}]}`, gvr, pubkey, otherPubkey),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := w.ImportSlashingProtection(ctx, []byte(test.data), gvr)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	// Invalid data is not partially imported, lower watermarks are ignored
+	// and higher watermarks are applied.
+	exported, err = w.ExportSlashingProtection(ctx, gvr)
+	require.NoError(t, err)
+	interchange := &interchangeJSON{}
+	require.NoError(t, json.Unmarshal(exported, interchange))
+	require.Len(t, interchange.Data, 2)
+	for _, entry := range interchange.Data {
+		if entry.Pubkey == pubkey {
+			require.Equal(t, []*interchangeBlockJSON{{Slot: "20"}}, entry.SignedBlocks)
+			require.Equal(t, []*interchangeAttestationJSON{{SourceEpoch: "4", TargetEpoch: "5"}}, entry.SignedAttestations)
+		} else {
+			require.Equal(t, otherPubkey, entry.Pubkey)
+			require.Equal(t, []*interchangeBlockJSON{{Slot: "1"}}, entry.SignedBlocks)
+			require.Empty(t, entry.SignedAttestations)
+		}
+	}
+
+	_, err = a.SignProposalRoot(ctx, 20, root)
+	require.ErrorIs(t, err, ErrSlashable)
+	_, err = a.SignProposalRoot(ctx, 21, root)
+	require.NoError(t, err)
+}
+
+func TestSlashingProtectionImportSameHeight(t *testing.T) {
+	ctx := context.Background()
+	gvr := bytes.Repeat([]byte{0x04}, 32)
+	root1 := bytes.Repeat([]byte{0x01}, 32)
+	root2 := bytes.Repeat([]byte{0x02}, 32)
+
+	tests := []struct {
+		name        string
+		block       string
+		attestation string
+		slashable   bool
+	}{
+		{
+			name:        "SameRoot",
+			block:       fmt.Sprintf(`{"slot":"10","signing_root":"%#x"}`, root1),
+			attestation: fmt.Sprintf(`{"source_epoch":"2","target_epoch":"3","signing_root":"%#x"}`, root1),
+		},
+		{
+			name:        "DifferentRoot",
+			block:       fmt.Sprintf(`{"slot":"10","signing_root":"%#x"}`, root2),
+			attestation: fmt.Sprintf(`{"source_epoch":"2","target_epoch":"3","signing_root":"%#x"}`, root2),
+			slashable:   true,
+		},
+		{
+			name:        "NoRoot",
+			block:       `{"slot":"10"}`,
+			attestation: `{"source_epoch":"2","target_epoch":"3"}`,
+			slashable:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, a := newProtectionTestAccount(ctx, t, scratch.New())
+			_, err := a.SignProposalRoot(ctx, 10, root1)
+			require.NoError(t, err)
+			_, err = a.SignAttestationRoot(ctx, 2, 3, root1)
+			require.NoError(t, err)
+
+			data := fmt.Sprintf(`{"metadata":{"interchange_format_version":"5","genesis_validators_root":"%#x"},"data":[{"pubkey":"%#x","signed_blocks":[%s],"signed_attestations":[%s]}]}`, gvr, a.PublicKey().Marshal(), test.block, test.attestation)
+			require.NoError(t, w.ImportSlashingProtection(ctx, []byte(data), gvr))
+
+			_, err = a.SignProposalRoot(ctx, 10, root1)
+			if test.slashable {
+				require.ErrorIs(t, err, ErrSlashable)
+			} else {
+				require.NoError(t, err)
+			}
+			_, err = a.SignAttestationRoot(ctx, 2, 3, root1)
+			if test.slashable {
+				require.ErrorIs(t, err, ErrSlashable)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// interchangeBlockJSON is a signed block, as used both in EIP-3076
+// interchange data and in stored slashing protection records.
+type interchangeBlockJSON struct {
+	Slot        string `json:"slot"`
+	SigningRoot string `json:"signing_root,omitempty"`
+}
+
+// interchangeAttestationJSON is a signed attestation, as used both in
+// EIP-3076 interchange data and in stored slashing protection records.
+type interchangeAttestationJSON struct {
+	SourceEpoch string `json:"source_epoch"`
+	TargetEpoch string `json:"target_epoch"`
+	SigningRoot string `json:"signing_root,omitempty"`
+}
+
+type interchangeMetadataJSON struct {
+	InterchangeFormatVersion string `json:"interchange_format_version"`
+	GenesisValidatorsRoot    string `json:"genesis_validators_root"`
+}
+
+type interchangeDataJSON struct {
+	Pubkey             string                        `json:"pubkey"`
+	SignedBlocks       []*interchangeBlockJSON       `json:"signed_blocks"`
+	SignedAttestations []*interchangeAttestationJSON `json:"signed_attestations"`
+}
+
+type interchangeJSON struct {
+	Metadata *interchangeMetadataJSON `json:"metadata"`
+	Data     []*interchangeDataJSON   `json:"data"`
+}
+
+type protectionRecordJSON struct {
+	UUID        uuid.UUID                   `json:"uuid"`
+	Record      string                      `json:"record"`
+	Pubkey      string                      `json:"pubkey"`
+	Block       *interchangeBlockJSON       `json:"block,omitempty"`
+	Attestation *interchangeAttestationJSON `json:"attestation,omitempty"`
+}
+
+func (r *protectionRecord) MarshalJSON() ([]byte, error) {
+	res, err := json.Marshal(r.toJSON())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal JSON")
+	}
+
+	return res, nil
+}
+
+// toJSON provides the JSON representation of the record.
+func (r *protectionRecord) toJSON() *protectionRecordJSON {
+	data := &protectionRecordJSON{
+		UUID:   r.id,
+		Record: protectionRecordType,
+		Pubkey: fmt.Sprintf("%#x", r.pubkey),
+	}
+	if r.blockSigned {
+		data.Block = &interchangeBlockJSON{
+			Slot:        strconv.FormatUint(r.slot, 10),
+			SigningRoot: formatRoot(r.blockRoot),
+		}
+	}
+	if r.attestationSigned {
+		data.Attestation = &interchangeAttestationJSON{
+			SourceEpoch: strconv.FormatUint(r.sourceEpoch, 10),
+			TargetEpoch: strconv.FormatUint(r.targetEpoch, 10),
+			SigningRoot: formatRoot(r.attestationRoot),
+		}
+	}
+
+	return data
+}
+
+func (r *protectionRecord) UnmarshalJSON(input []byte) error {
+	data := protectionRecordJSON{}
+	if err := json.Unmarshal(input, &data); err != nil {
+		return errors.Wrap(err, "invalid JSON")
+	}
+	if data.Record != protectionRecordType {
+		return errors.New("not a slashing protection record")
+	}
+	r.id = data.UUID
+	var err error
+	r.pubkey, err = parseHex(data.Pubkey)
+	if err != nil {
+		return errors.Wrap(err, "invalid pubkey")
+	}
+	if data.Block != nil {
+		r.blockSigned = true
+		if r.slot, r.blockRoot, err = parseInterchangeBlock(data.Block); err != nil {
+			return err
+		}
+	}
+	if data.Attestation != nil {
+		r.attestationSigned = true
+		if r.sourceEpoch, r.targetEpoch, r.attestationRoot, err = parseInterchangeAttestation(data.Attestation); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseInterchangeBlock parses a signed block.
+func parseInterchangeBlock(data *interchangeBlockJSON) (uint64, []byte, error) {
+	slot, err := strconv.ParseUint(data.Slot, 10, 64)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "invalid slot")
+	}
+	root, err := parseRoot(data.SigningRoot)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return slot, root, nil
+}
+
+// parseInterchangeAttestation parses a signed attestation.
+func parseInterchangeAttestation(data *interchangeAttestationJSON) (uint64, uint64, []byte, error) {
+	sourceEpoch, err := strconv.ParseUint(data.SourceEpoch, 10, 64)
+	if err != nil {
+		return 0, 0, nil, errors.Wrap(err, "invalid source epoch")
+	}
+	targetEpoch, err := strconv.ParseUint(data.TargetEpoch, 10, 64)
+	if err != nil {
+		return 0, 0, nil, errors.Wrap(err, "invalid target epoch")
+	}
+	if sourceEpoch > targetEpoch {
+		return 0, 0, nil, fmt.Errorf("source epoch %d after target epoch %d", sourceEpoch, targetEpoch)
+	}
+	root, err := parseRoot(data.SigningRoot)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	return sourceEpoch, targetEpoch, root, nil
+}
+
+// parseRoot parses an optional signing root.
+func parseRoot(input string) ([]byte, error) {
+	if input == "" {
+		return nil, nil
+	}
+	root, err := parseHex(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid signing root")
+	}
+	if len(root) != 32 {
+		return nil, errors.New("invalid signing root length")
+	}
+
+	return root, nil
+}
+
+// formatRoot formats an optional signing root.
+func formatRoot(root []byte) string {
+	if root == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%#x", root)
+}
+
+// parseHex parses a hex string, with or without 0x prefix.
+func parseHex(input string) ([]byte, error) {
+	return hex.DecodeString(strings.TrimPrefix(input, "0x"))
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	e2types "github.com/wealdtech/go-eth2-types/v2"
+	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// Verify returns true if the signature is a valid signature of the data by
+// the account.  The account does not need to be unlocked.
+func (a *account) Verify(data []byte, sig e2types.Signature) bool {
+	if sig == nil {
+		return false
+	}
+
+	return sig.Verify(data, a.publicKey)
+}
+
+// VerifyAggregate returns true if the aggregate signature is a valid signature
+// where messages[i] was signed by the account with ID ids[i].
+// Aggregate verification is vulnerable to rogue public keys, but the public
+// keys used here are those of accounts for which the wallet holds the private
+// keys.
+// All messages must be the same length, as the underlying verification
+// truncates or pads each message to the length of the first.
+func (w *wallet) VerifyAggregate(ctx context.Context,
+	ids []uuid.UUID,
+	messages [][]byte,
+	aggSig e2types.Signature,
+) (
+	bool,
+	error,
+) {
+	if len(ids) == 0 {
+		return false, errors.New("no accounts supplied")
+	}
+	if len(ids) != len(messages) {
+		return false, errors.New("number of accounts and messages do not match")
+	}
+	for i := range messages {
+		if len(messages[i]) != len(messages[0]) {
+			return false, errors.New("messages must all be the same length")
+		}
+	}
+	if aggSig == nil {
+		return false, errors.New("no signature supplied")
+	}
+
+	pubKeys := make([]e2types.PublicKey, len(ids))
+	for i, id := range ids {
+		account, err := w.AccountByID(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		pubKeys[i] = account.(e2wtypes.AccountPublicKeyProvider).PublicKey()
+	}
+
+	return aggSig.VerifyAggregate(messages, pubKeys), nil
+}
@@ -0,0 +1,134 @@
+// Copyright © 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	e2types "github.com/wealdtech/go-eth2-types/v2"
+	keystorev4 "github.com/wealdtech/go-eth2-wallet-encryptor-keystorev4"
+	scratch "github.com/wealdtech/go-eth2-wallet-store-scratch"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	_, a := newProtectionTestAccount(ctx, t, scratch.New())
+
+	signature, err := a.Sign(ctx, []byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, a.Lock(ctx))
+
+	require.True(t, a.Verify([]byte("data"), signature))
+	require.False(t, a.Verify([]byte("other data"), signature))
+	require.False(t, a.Verify([]byte("data"), nil))
+}
+
+func TestVerifyAggregate(t *testing.T) {
+	ctx := context.Background()
+	w, err := CreateWallet(ctx, "test wallet", scratch.New(), keystorev4.New())
+	require.NoError(t, err)
+	wallet := w.(*wallet)
+	require.NoError(t, wallet.Unlock(ctx, nil))
+
+	ids := make([]uuid.UUID, 3)
+	messages := make([][]byte, 3)
+	signatures := make([]e2types.Signature, 3)
+	for i := range ids {
+		acc, err := wallet.CreateAccount(ctx, fmt.Sprintf("account %d", i), []byte("pass"))
+		require.NoError(t, err)
+		require.NoError(t, acc.(*account).Unlock(ctx, []byte("pass")))
+		ids[i] = acc.ID()
+		messages[i] = []byte(fmt.Sprintf("message %d", i))
+		signatures[i], err = acc.(*account).Sign(ctx, messages[i])
+		require.NoError(t, err)
+	}
+	aggSig := e2types.AggregateSignatures(signatures)
+
+	tests := []struct {
+		name     string
+		ids      []uuid.UUID
+		messages [][]byte
+		sig      e2types.Signature
+		verified bool
+		err      string
+	}{
+		{
+			name: "Empty",
+			sig:  aggSig,
+			err:  "no accounts supplied",
+		},
+		{
+			name:     "Mismatch",
+			ids:      ids,
+			messages: messages[:2],
+			sig:      aggSig,
+			err:      "number of accounts and messages do not match",
+		},
+		{
+			name:     "MessageLengthMismatch",
+			ids:      ids,
+			messages: [][]byte{messages[0], append(append([]byte{}, messages[1]...), 0xde, 0xad), messages[2]},
+			sig:      aggSig,
+			err:      "messages must all be the same length",
+		},
+		{
+			name:     "SignatureMissing",
+			ids:      ids,
+			messages: messages,
+			err:      "no signature supplied",
+		},
+		{
+			name:     "UnknownAccount",
+			ids:      []uuid.UUID{ids[0], ids[1], uuid.New()},
+			messages: messages,
+			sig:      aggSig,
+			err:      "failed to retrieve account: account not found",
+		},
+		{
+			name:     "Good",
+			ids:      ids,
+			messages: messages,
+			sig:      aggSig,
+			verified: true,
+		},
+		{
+			name:     "WrongOrder",
+			ids:      []uuid.UUID{ids[1], ids[0], ids[2]},
+			messages: messages,
+			sig:      aggSig,
+		},
+		{
+			name:     "Partial",
+			ids:      ids[:2],
+			messages: messages[:2],
+			sig:      aggSig,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verified, err := wallet.VerifyAggregate(ctx, test.ids, test.messages, test.sig)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, test.verified, verified)
+			}
+		})
+	}
+}
@@ -17,10 +17,11 @@ package nd
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
-	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -42,30 +43,79 @@ type wallet struct {
 	version        uint
 	store          e2wtypes.Store
 	encryptor      e2wtypes.Encryptor
+	crypto         map[string]any
 	unlocked       bool
 	index          *indexer.Index
+	pubkeys        *pubkeyIndex
 	batch          *batch
 	accounts       map[uuid.UUID]*account
+	accountsMutex  sync.RWMutex
 	mutex          sync.Mutex
 	batchMutex     sync.Mutex
 	batchDecrypted bool
+	unlockTimeout  time.Duration
+	idleTimeout    time.Duration
+	clock          func() time.Time
+	afterFunc      func(time.Duration, func()) Timer
+	allowDupKeys   bool
+	protection     map[string]*protectionRecord
+	protectMutex   sync.Mutex
+	auditSink      AuditSink
+	batchName      string
+	// batchKeys holds the private keys from the decrypted batch, by account.
+	batchKeys map[uuid.UUID][]byte
+	// batchPassphraseHash is the hash of the passphrase that decrypted the batch.
+	batchPassphraseHash []byte
 }
 
 // newWallet creates a new wallet.
 func newWallet() *wallet {
 	return &wallet{
-		index:    indexer.New(),
-		accounts: make(map[uuid.UUID]*account),
+		index:     indexer.New(),
+		pubkeys:   newPubkeyIndex(),
+		accounts:  make(map[uuid.UUID]*account),
+		clock:     time.Now,
+		afterFunc: afterFunc,
+	}
+}
+
+// applyOptions applies runtime options to the wallet.
+func (w *wallet) applyOptions(options *options) {
+	w.unlockTimeout = options.unlockTimeout
+	w.idleTimeout = options.idleTimeout
+	w.clock = options.clock
+	w.afterFunc = options.afterFunc
+	w.allowDupKeys = options.allowDupKeys
+	w.auditSink = options.auditSink
+	w.batchName = options.batchName
+	if options.ignoreBatch {
+		// An empty batch stops the stored batch from being retrieved.
+		w.batch = &batch{}
 	}
 }
 
 // CreateWallet creates a new wallet with the given name and stores it in the provided store.
 // This will error if the wallet already exists.
-func CreateWallet(ctx context.Context, name string, store e2wtypes.Store, encryptor e2wtypes.Encryptor) (e2wtypes.Wallet, error) {
-	// First, try to open the wallet.
-	_, err := OpenWallet(ctx, name, store, encryptor)
-	if err == nil || !strings.Contains(err.Error(), "wallet not found") {
-		return nil, fmt.Errorf("wallet %q already exists", name)
+func CreateWallet(ctx context.Context,
+	name string,
+	store e2wtypes.Store,
+	encryptor e2wtypes.Encryptor,
+	opts ...Option,
+) (
+	e2wtypes.Wallet,
+	error,
+) {
+	options := parseOptions(opts)
+
+	// First, ensure the wallet does not already exist.  Only an error stating
+	// that the wallet was not found shows that it does not exist; any other
+	// error could hide an existing wallet.
+	_, err := store.RetrieveWallet(name)
+	if err == nil {
+		return nil, newError(ErrWalletExists, "wallet %q already exists", name)
+	}
+	if !strings.Contains(err.Error(), "wallet not found") {
+		return nil, errors.Wrap(err, "failed to check for existing wallet")
 	}
 
 	id, err := uuid.NewRandom()
@@ -79,18 +129,43 @@ func CreateWallet(ctx context.Context, name string, store e2wtypes.Store, encryp
 	w.version = version
 	w.store = store
 	w.encryptor = encryptor
+	w.pubkeys.SetComplete()
+	w.applyOptions(options)
+	if options.passphrase != nil {
+		// Encrypt some random data with the passphrase, to verify it when unlocking.
+		verifier := make([]byte, 32)
+		if _, err := rand.Read(verifier); err != nil {
+			return nil, errors.Wrap(err, "failed to generate passphrase verifier")
+		}
+		w.crypto, err = encryptor.Encrypt(verifier, string(options.passphrase))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encrypt passphrase verifier")
+		}
+	}
 
 	return w, w.storeWallet()
 }
 
 // OpenWallet opens an existing wallet with the given name.
-func OpenWallet(ctx context.Context, name string, store e2wtypes.Store, encryptor e2wtypes.Encryptor) (e2wtypes.Wallet, error) {
+func OpenWallet(ctx context.Context,
+	name string,
+	store e2wtypes.Store,
+	encryptor e2wtypes.Encryptor,
+	opts ...Option,
+) (
+	e2wtypes.Wallet,
+	error,
+) {
 	data, err := store.RetrieveWallet(name)
 	if err != nil {
-		return nil, errors.Wrapf(err, "wallet %q does not exist", name)
+		if !strings.Contains(err.Error(), "wallet not found") {
+			return nil, errors.Wrapf(err, "failed to retrieve wallet %q", name)
+		}
+
+		return nil, newError(ErrWalletNotFound, "wallet %q does not exist: %v", name, err)
 	}
 
-	return DeserializeWallet(ctx, data, store, encryptor)
+	return DeserializeWallet(ctx, data, store, encryptor, opts...)
 }
 
 // DeserializeWallet deserializes a wallet from its byte-level representation.
@@ -98,6 +173,7 @@ func DeserializeWallet(ctx context.Context,
 	data []byte,
 	store e2wtypes.Store,
 	encryptor e2wtypes.Encryptor,
+	opts ...Option,
 ) (
 	e2wtypes.Wallet,
 	error,
@@ -108,6 +184,7 @@ func DeserializeWallet(ctx context.Context,
 	}
 	wallet.store = store
 	wallet.encryptor = encryptor
+	wallet.applyOptions(parseOptions(opts))
 	if err := wallet.retrieveAccountsIndex(ctx); err != nil {
 		return nil, errors.Wrap(err, "wallet index corrupt")
 	}
@@ -142,7 +219,13 @@ func (w *wallet) Lock(_ context.Context) error {
 }
 
 // Unlock unlocks the wallet.  An unlocked wallet can create new accounts.
-func (w *wallet) Unlock(_ context.Context, _ []byte) error {
+// If the wallet was created with a passphrase then it must be supplied.
+func (w *wallet) Unlock(_ context.Context, passphrase []byte) error {
+	if w.crypto != nil {
+		if _, err := w.encryptor.Decrypt(w.crypto, string(passphrase)); err != nil {
+			return ErrIncorrectPassphrase
+		}
+	}
 	w.unlocked = true
 	return nil
 }
@@ -166,22 +249,31 @@ func (w *wallet) storeWallet() error {
 	return w.store.StoreWallet(w.ID(), w.Name(), data)
 }
 
-// CreateAccount creates a new account in the wallet.
-// The only rule for names is that they cannot start with an underscore (_) character.
-func (w *wallet) CreateAccount(ctx context.Context, name string, passphrase []byte) (e2wtypes.Account, error) {
+// checkAccountName ensures that an account name is valid.
+func checkAccountName(name string) error {
 	if name == "" {
-		return nil, errors.New("account name missing")
+		return newError(ErrInvalidName, "account name missing")
 	}
 	if strings.HasPrefix(name, "_") {
-		return nil, fmt.Errorf("invalid account name %q", name)
+		return newError(ErrInvalidName, "invalid account name %q", name)
+	}
+
+	return nil
+}
+
+// CreateAccount creates a new account in the wallet.
+// The only rule for names is that they cannot start with an underscore (_) character.
+func (w *wallet) CreateAccount(ctx context.Context, name string, passphrase []byte) (e2wtypes.Account, error) {
+	if err := checkAccountName(name); err != nil {
+		return nil, err
 	}
 	if !w.unlocked {
-		return nil, errors.New("wallet must be unlocked to create accounts")
+		return nil, newError(ErrWalletLocked, "wallet must be unlocked to create accounts")
 	}
 
 	// Ensure that we don't already have an account with this name
 	if _, err := w.AccountByName(ctx, name); err == nil {
-		return nil, fmt.Errorf("account with name %q already exists", name)
+		return nil, newError(ErrAccountExists, "account with name %q already exists", name)
 	}
 
 	a := newAccount()
@@ -213,30 +305,34 @@ func (w *wallet) CreateAccount(ctx context.Context, name string, passphrase []by
 		w.mutex.Unlock()
 		return nil, err
 	}
-	w.accounts[a.id] = a
+	w.setLoadedAccount(a)
+	w.pubkeys.Add(a.publicKey.Marshal(), a.id)
 	w.mutex.Unlock()
 
+	if err := w.audit(ctx, AuditCreateAccount, a, nil); err != nil {
+		return nil, err
+	}
+
 	return a, nil
 }
 
 // ImportAccount creates a new account in the wallet from an existing private key.
 // The only rule for names is that they cannot start with an underscore (_) character.
-// This will error if an account with the name already exists.
+// This will error if an account with the name already exists, or if an account
+// with the same private key already exists unless the wallet was opened with
+// WithAllowDuplicateKeys.
 func (w *wallet) ImportAccount(ctx context.Context, name string, key []byte, passphrase []byte) (e2wtypes.Account, error) {
-	if name == "" {
-		return nil, errors.New("account name missing")
-	}
-	if strings.HasPrefix(name, "_") {
-		return nil, fmt.Errorf("invalid account name %q", name)
+	if err := checkAccountName(name); err != nil {
+		return nil, err
 	}
 	if !w.unlocked {
-		return nil, errors.New("wallet must be unlocked to import accounts")
+		return nil, newError(ErrWalletLocked, "wallet must be unlocked to import accounts")
 	}
 
 	// Ensure that we don't already have an account with this name.
 	_, err := w.AccountByName(ctx, name)
 	if err == nil {
-		return nil, fmt.Errorf("account with name %q already exists", name)
+		return nil, newError(ErrAccountExists, "account with name %q already exists", name)
 	}
 
 	a := newAccount()
@@ -250,6 +346,14 @@ func (w *wallet) ImportAccount(ctx context.Context, name string, key []byte, pas
 		return nil, errors.Wrap(err, "failed to decode private key")
 	}
 	a.publicKey = privateKey.PublicKey()
+
+	// Ensure that we don't already have an account with this key.
+	if !w.allowDupKeys {
+		if existing, err := w.AccountByPublicKey(ctx, a.publicKey.Marshal()); err == nil {
+			return nil, newError(ErrDuplicateKey, "account %q already has this key", existing.Name())
+		}
+	}
+
 	// Encrypt the private key.
 	a.crypto, err = w.encryptor.Encrypt(privateKey.Marshal(), string(passphrase))
 	if err != nil {
@@ -268,18 +372,130 @@ func (w *wallet) ImportAccount(ctx context.Context, name string, key []byte, pas
 		w.mutex.Unlock()
 		return nil, err
 	}
-	w.accounts[a.id] = a
+	w.setLoadedAccount(a)
+	w.pubkeys.Add(a.publicKey.Marshal(), a.id)
 	w.mutex.Unlock()
 
+	if err := w.audit(ctx, AuditImportAccount, a, nil); err != nil {
+		return nil, err
+	}
+
 	return a, nil
 }
 
+// DeleteAccount deletes an account from the wallet.
+// Stores cannot remove data, so the stored account is replaced by a tombstone
+// that holds no key material.  Any existing batch is marked as stale.
+// If the account has been loaded it is locked, clearing its private key.
+func (w *wallet) DeleteAccount(ctx context.Context, id uuid.UUID) error {
+	if !w.unlocked {
+		return newError(ErrWalletLocked, "wallet must be unlocked to delete accounts")
+	}
+
+	name, exists := w.index.Name(id)
+	if !exists {
+		return newError(ErrAccountNotFound, "no account with ID %s", id)
+	}
+
+	// Ensure the batch is loaded, so that its accounts can be removed.
+	_ = w.retrieveBatchIfRequired(ctx)
+
+	// Have to update the index first so that storeTombstone() stores the
+	// index with the account absent, but be ready to revert if it fails.
+	w.mutex.Lock()
+	w.index.Remove(id, name)
+	if err := w.storeTombstone(id); err != nil {
+		w.index.Add(id, name)
+		// Best effort to put the stored index back as it was.
+		_ = w.storeAccountsIndex()
+		w.mutex.Unlock()
+		return err
+	}
+	loaded, isLoaded := w.loadedAccount(id)
+	w.unloadAccount(id)
+	w.pubkeys.RemoveID(id)
+	w.mutex.Unlock()
+
+	if isLoaded {
+		// Lock the account, as callers may still hold it, to clear its key.
+		_ = loaded.Lock(ctx)
+	}
+
+	w.batchMutex.Lock()
+	if w.batch != nil && len(w.batch.entries) > 0 {
+		w.batch.stale = true
+	}
+	w.batchMutex.Unlock()
+
+	return nil
+}
+
+// RenameAccount renames an account in the wallet.
+// The account keeps its ID and key.  Name rules are the same as for CreateAccount().
+// Any batch keeps the old name, as its entries cannot be changed without its
+// passphrase; the new name is used regardless, and BatchStatus reports the
+// account as renamed until the batch is updated.
+func (w *wallet) RenameAccount(ctx context.Context, id uuid.UUID, name string) error {
+	if err := checkAccountName(name); err != nil {
+		return err
+	}
+	if !w.unlocked {
+		return newError(ErrWalletLocked, "wallet must be unlocked to rename accounts")
+	}
+
+	oldName, exists := w.index.Name(id)
+	if !exists {
+		return newError(ErrAccountNotFound, "no account with ID %s", id)
+	}
+
+	// Ensure that we don't already have an account with this name.
+	if _, err := w.AccountByName(ctx, name); err == nil {
+		return newError(ErrAccountExists, "account with name %q already exists", name)
+	}
+
+	// Work from the stored account, as accounts obtained from a batch
+	// do not hold their own crypto.
+	data, err := w.store.RetrieveAccount(w.id, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to retrieve account")
+	}
+	a, err := deserializeAccount(w, data)
+	if err != nil {
+		return err
+	}
+	a.name = name
+
+	// Have to update the index first so that storeAccount() stores the
+	// index with the new name present, but be ready to revert if it fails.
+	w.mutex.Lock()
+	loaded, isLoaded := w.loadedAccount(id)
+	w.index.Remove(id, oldName)
+	w.index.Add(id, name)
+	if err := a.storeAccount(ctx); err != nil {
+		w.index.Remove(id, name)
+		w.index.Add(id, oldName)
+		// Best effort to put the stored index back as it was.
+		_ = w.storeAccountsIndex()
+		w.mutex.Unlock()
+		return err
+	}
+	if isLoaded {
+		loaded.mutex.Lock()
+		loaded.name = name
+		loaded.mutex.Unlock()
+		w.setLoadedAccount(loaded)
+	}
+	w.mutex.Unlock()
+
+	return nil
+}
+
 func (w *wallet) retrieveBatchIfRequired(ctx context.Context) error {
 	var err error
 
 	if w.batch == nil {
 		// Batch not retrieved, try to retrieve it now.
-		if _, isBatchRetriever := w.store.(e2wtypes.BatchRetriever); isBatchRetriever {
+		if w.canRetrieveBatches() {
 			err = w.retrieveAccountsBatch(ctx)
 		}
 	}
@@ -295,10 +511,23 @@ func (w *wallet) Accounts(ctx context.Context) <-chan e2wtypes.Account {
 		_ = w.retrieveBatchIfRequired(ctx)
 
 		if w.batch != nil && len(w.batch.entries) > 0 {
-			// Batch present, use pre-loaded accounts.
-			for _, account := range w.accounts {
+			// Batch present, use pre-loaded accounts and add any accounts
+			// created since the batch.
+			seen := make(map[uuid.UUID]bool)
+			for _, account := range w.loadedAccounts() {
+				seen[account.id] = true
 				ch <- account
 			}
+			if names, err := w.indexedAccounts(); err == nil {
+				for id := range names {
+					if seen[id] {
+						continue
+					}
+					if account, err := w.AccountByID(ctx, id); err == nil {
+						ch <- account
+					}
+				}
+			}
 			close(ch)
 
 			return
@@ -307,7 +536,7 @@ func (w *wallet) Accounts(ctx context.Context) <-chan e2wtypes.Account {
 		// No batch; fall back to individual accounts on the store.
 		for data := range w.store.RetrieveAccounts(w.ID()) {
 			if account, err := deserializeAccount(w, data); err == nil {
-				ch <- account
+				ch <- w.loadAccount(account)
 			}
 		}
 		close(ch)
@@ -329,6 +558,9 @@ func (w *wallet) Export(ctx context.Context, passphrase []byte) ([]byte, error)
 	accounts := make([]*account, 0)
 	for data := range w.store.RetrieveAccounts(w.ID()) {
 		account, err := deserializeAccount(w, data)
+		if errors.Is(err, errNotAccount) {
+			continue
+		}
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to deserialize account")
 		}
@@ -385,7 +617,7 @@ func Import(ctx context.Context,
 
 	// See if the wallet already exists.
 	if _, err := OpenWallet(ctx, ext.Wallet.Name(), store, encryptor); err == nil {
-		return nil, fmt.Errorf("wallet %q already exists", ext.Wallet.Name())
+		return nil, newError(ErrWalletExists, "wallet %q already exists", ext.Wallet.Name())
 	}
 
 	// Store the wallet.
@@ -398,10 +630,12 @@ func Import(ctx context.Context,
 		acc.wallet = ext.Wallet
 		acc.encryptor = encryptor
 		ext.Wallet.index.Add(acc.id, acc.name)
+		ext.Wallet.pubkeys.Add(acc.publicKey.Marshal(), acc.id)
 		if err := acc.storeAccount(ctx); err != nil {
 			return nil, errors.Wrapf(err, "failed to store account %q", acc.Name())
 		}
 	}
+	ext.Wallet.pubkeys.SetComplete()
 
 	if err := ext.Wallet.storeAccountsIndex(); err != nil {
 		return nil, errors.Wrap(err, "failed to store wallet index")
@@ -415,7 +649,7 @@ func Import(ctx context.Context,
 func (w *wallet) AccountByName(ctx context.Context, name string) (e2wtypes.Account, error) {
 	id, exists := w.index.ID(name)
 	if !exists {
-		return nil, fmt.Errorf("no account with name %q", name)
+		return nil, newError(ErrAccountNotFound, "no account with name %q", name)
 	}
 
 	return w.AccountByID(ctx, id)
@@ -426,25 +660,90 @@ func (w *wallet) AccountByName(ctx context.Context, name string) (e2wtypes.Accou
 func (w *wallet) AccountByID(ctx context.Context, id uuid.UUID) (e2wtypes.Account, error) {
 	_ = w.retrieveBatchIfRequired(ctx)
 
-	if w.batch != nil && len(w.batch.entries) > 0 {
-		// Batch present, use pre-loaded account if available.
-		if account, exists := w.accounts[id]; exists {
-			return account, nil
-		}
+	// Use pre-loaded account if available.
+	if account, exists := w.loadedAccount(id); exists {
+		return account, nil
 	}
 
-	// No batch or account not in batch; fall back to individual account on the store.
+	// Account not loaded; fall back to individual account on the store.
 	data, err := w.store.RetrieveAccount(w.id, id)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to retrieve account")
+		return nil, newError(ErrAccountNotFound, "failed to retrieve account: %v", err)
 	}
 	res, err := deserializeAccount(w, data)
+	if errors.Is(err, errNotAccount) {
+		return nil, newError(ErrAccountNotFound, "no account with ID %s", id)
+	}
 	if err != nil {
 		return nil, err
 	}
-	w.accounts[id] = res
 
-	return res, nil
+	return w.loadAccount(res), nil
+}
+
+// loadedAccount provides an account that has already been loaded.
+func (w *wallet) loadedAccount(id uuid.UUID) (*account, bool) {
+	w.accountsMutex.RLock()
+	account, exists := w.accounts[id]
+	w.accountsMutex.RUnlock()
+
+	return account, exists
+}
+
+// loadedAccounts provides all accounts that have been loaded.
+func (w *wallet) loadedAccounts() []*account {
+	w.accountsMutex.RLock()
+	accounts := make([]*account, 0, len(w.accounts))
+	for _, account := range w.accounts {
+		accounts = append(accounts, account)
+	}
+	w.accountsMutex.RUnlock()
+
+	return accounts
+}
+
+// loadAccount records an account as loaded, unless it has already been
+// loaded in which case the existing account is returned.
+func (w *wallet) loadAccount(a *account) *account {
+	w.accountsMutex.Lock()
+	defer w.accountsMutex.Unlock()
+
+	if existing, exists := w.accounts[a.id]; exists {
+		return existing
+	}
+	w.accounts[a.id] = a
+
+	return a
+}
+
+// setLoadedAccount records an account as loaded, replacing any existing account.
+func (w *wallet) setLoadedAccount(a *account) {
+	w.accountsMutex.Lock()
+	w.accounts[a.id] = a
+	w.accountsMutex.Unlock()
+}
+
+// unloadAccount removes an account from the loaded accounts.
+func (w *wallet) unloadAccount(id uuid.UUID) {
+	w.accountsMutex.Lock()
+	delete(w.accounts, id)
+	w.accountsMutex.Unlock()
+}
+
+// LockAll locks all accounts that have been loaded from the wallet, clearing
+// their private keys from memory.
+func (w *wallet) LockAll(ctx context.Context) error {
+	for _, account := range w.loadedAccounts() {
+		if err := account.Lock(ctx); err != nil {
+			return errors.Wrapf(err, "failed to lock account %q", account.Name())
+		}
+	}
+
+	w.batchMutex.Lock()
+	w.clearBatchKeys()
+	w.batchMutex.Unlock()
+
+	return nil
 }
 
 // Store returns the wallet's store.
@@ -453,14 +752,19 @@ func (w *wallet) Store() e2wtypes.Store {
 }
 
 // retrieveAccountsIndex retrieves the accounts index for a wallet.
-func (w *wallet) retrieveAccountsIndex(ctx context.Context) error {
+func (w *wallet) retrieveAccountsIndex(_ context.Context) error {
 	serializedIndex, err := w.store.RetrieveAccountsIndex(w.id)
 	if err != nil {
-		// Attempt to recreate the index.
+		// Attempt to recreate the index from the accounts on the store.
 		w.index = indexer.New()
-		for account := range w.Accounts(ctx) {
-			w.index.Add(account.ID(), account.Name())
+		w.pubkeys = newPubkeyIndex()
+		for data := range w.store.RetrieveAccounts(w.ID()) {
+			if account, err := deserializeAccount(w, data); err == nil {
+				w.index.Add(account.ID(), account.Name())
+				w.pubkeys.Add(account.publicKey.Marshal(), account.id)
+			}
 		}
+		w.pubkeys.SetComplete()
 		if err := w.storeAccountsIndex(); err != nil {
 			return err
 		}
@@ -470,11 +774,35 @@ func (w *wallet) retrieveAccountsIndex(ctx context.Context) error {
 			return errors.Wrap(err, "failed to deserialize index")
 		}
 		w.index = index
+		w.pubkeys = newPubkeyIndex()
 	}
 
 	return nil
 }
 
+// indexedAccounts provides the names of all accounts in the index, keyed by ID.
+func (w *wallet) indexedAccounts() (map[uuid.UUID]string, error) {
+	// The index cannot be iterated over, so use its serialized form.
+	serializedIndex, err := w.index.Serialize()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to serialize index")
+	}
+	entries := make([]struct {
+		ID   uuid.UUID `json:"uuid"`
+		Name string    `json:"name"`
+	}, 0)
+	if err := json.Unmarshal(serializedIndex, &entries); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal index")
+	}
+
+	res := make(map[uuid.UUID]string, len(entries))
+	for _, entry := range entries {
+		res[entry.ID] = entry.Name
+	}
+
+	return res, nil
+}
+
 // storeAccountsIndex stores the accounts index for a wallet.
 func (w *wallet) storeAccountsIndex() error {
 	serializedIndex, err := w.index.Serialize()
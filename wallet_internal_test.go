@@ -28,6 +28,7 @@ import (
 	"github.com/stretchr/testify/require"
 	keystorev4 "github.com/wealdtech/go-eth2-wallet-encryptor-keystorev4"
 	filesystem "github.com/wealdtech/go-eth2-wallet-store-filesystem"
+	scratch "github.com/wealdtech/go-eth2-wallet-store-scratch"
 	e2wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
 )
 
@@ -114,6 +115,11 @@ func TestUnmarshalWallet(t *testing.T) {
 			input: []byte(`{"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","name":"Bad","type":"non-deterministic","version":"1"}`),
 			err:   errors.New("wallet version invalid"),
 		},
+		{
+			name:  "WrongCrypto",
+			input: []byte(`{"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","name":"Bad","type":"non-deterministic","version":1,"crypto":true}`),
+			err:   errors.New("wallet crypto invalid"),
+		},
 		{
 			name:       "Good",
 			input:      []byte(`{"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","name":"Good","type":"non-deterministic","version":1}`),
@@ -192,3 +198,249 @@ func TestRetrieveAccountsIndex(t *testing.T) {
 	_, found = w.(*wallet).index.ID("not present")
 	require.False(t, found)
 }
+
+func TestDeleteAccount(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+
+	// Try to delete without unlocking the wallet; should fail.
+	require.EqualError(t, w.(*wallet).DeleteAccount(ctx, uuid.New()), "wallet must be unlocked to delete accounts")
+
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+	account1, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account1", []byte("test"))
+	require.NoError(t, err)
+	account2, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account2", []byte("test"))
+	require.NoError(t, err)
+
+	// Unknown account.
+	unknownID := uuid.New()
+	require.EqualError(t, w.(*wallet).DeleteAccount(ctx, unknownID), fmt.Sprintf("no account with ID %s", unknownID))
+
+	require.NoError(t, account1.(e2wtypes.AccountLocker).Unlock(ctx, []byte("test")))
+	require.NoError(t, w.(*wallet).DeleteAccount(ctx, account1.ID()))
+	// The account is locked, so cannot sign with its key.
+	require.Nil(t, account1.(*account).secretKey)
+	_, err = account1.(e2wtypes.AccountSigner).Sign(ctx, []byte("test"))
+	require.ErrorIs(t, err, ErrAccountLocked)
+	_, err = w.(e2wtypes.WalletAccountByNameProvider).AccountByName(ctx, "account1")
+	require.EqualError(t, err, `no account with name "account1"`)
+	_, err = w.(e2wtypes.WalletAccountByIDProvider).AccountByID(ctx, account1.ID())
+	require.Error(t, err)
+
+	// Deleting again should fail.
+	require.Error(t, w.(*wallet).DeleteAccount(ctx, account1.ID()))
+
+	// Re-open the wallet and ensure only the remaining account is present.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	accounts := make([]e2wtypes.Account, 0)
+	for account := range w.Accounts(ctx) {
+		accounts = append(accounts, account)
+	}
+	require.Len(t, accounts, 1)
+	require.Equal(t, account2.ID(), accounts[0].ID())
+
+	// Export should skip the deleted account.
+	_, err = w.(e2wtypes.WalletExporter).Export(ctx, []byte("export"))
+	require.NoError(t, err)
+
+	// The name can be reused.
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+	_, err = w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account1", []byte("test"))
+	require.NoError(t, err)
+}
+
+func TestDeleteBatchAccount(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	account1, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account1", []byte("test"))
+	require.NoError(t, err)
+	account2, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account2", []byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletBatchCreator).BatchWallet(ctx, []string{"test"}, "batch passphrase"))
+
+	// Re-open the wallet to use the batch, and delete an account.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+	require.NoError(t, w.(*wallet).DeleteAccount(ctx, account1.ID()))
+	require.True(t, w.(*wallet).batch.stale)
+	numAccounts := 0
+	for range w.Accounts(ctx) {
+		numAccounts++
+	}
+	require.Equal(t, 1, numAccounts)
+
+	// Re-open the wallet again; the batch should be stale and the deleted account absent.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	numAccounts = 0
+	for range w.Accounts(ctx) {
+		numAccounts++
+	}
+	require.Equal(t, 1, numAccounts)
+	require.True(t, w.(*wallet).batch.stale)
+	_, err = w.(e2wtypes.WalletAccountByIDProvider).AccountByID(ctx, account1.ID())
+	require.Error(t, err)
+
+	// Remaining account should still unlock with the batch passphrase.
+	account, err := w.(e2wtypes.WalletAccountByIDProvider).AccountByID(ctx, account2.ID())
+	require.NoError(t, err)
+	require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("batch passphrase")))
+}
+
+func TestRenameAccount(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	account1, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account1", []byte("test"))
+	require.NoError(t, err)
+	_, err = w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account2", []byte("test"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		id      uuid.UUID
+		newName string
+		err     string
+	}{
+		{
+			name: "Empty",
+			id:   account1.ID(),
+			err:  "account name missing",
+		},
+		{
+			name:    "Invalid",
+			id:      account1.ID(),
+			newName: "_bad",
+			err:     `invalid account name "_bad"`,
+		},
+		{
+			name:    "Duplicate",
+			id:      account1.ID(),
+			newName: "account2",
+			err:     `account with name "account2" already exists`,
+		},
+		{
+			name:    "Unknown",
+			id:      uuid.Nil,
+			newName: "renamed",
+			err:     fmt.Sprintf("no account with ID %s", uuid.Nil),
+		},
+		{
+			name:    "Good",
+			id:      account1.ID(),
+			newName: "renamed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := w.(*wallet).RenameAccount(ctx, test.id, test.newName)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	// Re-open the wallet and confirm the rename.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	_, err = w.(e2wtypes.WalletAccountByNameProvider).AccountByName(ctx, "account1")
+	require.Error(t, err)
+	account, err := w.(e2wtypes.WalletAccountByNameProvider).AccountByName(ctx, "renamed")
+	require.NoError(t, err)
+	require.Equal(t, account1.ID(), account.ID())
+	require.Equal(t, "renamed", account.Name())
+	require.Equal(t, account1.PublicKey().Marshal(), account.PublicKey().Marshal())
+	require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("test")))
+
+	// Renaming requires an unlocked wallet.
+	require.EqualError(t, w.(*wallet).RenameAccount(ctx, account1.ID(), "again"), "wallet must be unlocked to rename accounts")
+}
+
+func TestRenameBatchAccount(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	w, err := CreateWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+
+	account1, err := w.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account1", []byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletBatchCreator).BatchWallet(ctx, []string{"test"}, "batch passphrase"))
+
+	// Re-open the wallet to use the batch, and rename the account.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.NoError(t, w.(e2wtypes.WalletLocker).Unlock(ctx, nil))
+	account, err := w.(e2wtypes.WalletAccountByIDProvider).AccountByID(ctx, account1.ID())
+	require.NoError(t, err)
+	require.NoError(t, w.(*wallet).RenameAccount(ctx, account1.ID(), "renamed"))
+	require.Equal(t, "renamed", account.Name())
+	// The batch is bound to its entries, so keeps the old name.
+	require.Equal(t, "account1", w.(*wallet).batch.entries[0].name)
+
+	// Re-open the wallet and confirm the account has the new name.
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	account, err = w.(e2wtypes.WalletAccountByNameProvider).AccountByName(ctx, "renamed")
+	require.NoError(t, err)
+	require.Equal(t, "renamed", account.Name())
+	status, err := w.(*wallet).BatchStatus(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []uuid.UUID{account1.ID()}, status.Renamed)
+	require.NoError(t, account.(e2wtypes.AccountLocker).Unlock(ctx, []byte("batch passphrase")))
+
+	// Update the batch to pick up the new name.
+	require.NoError(t, w.(*wallet).UpdateBatch(ctx, nil, "batch passphrase"))
+	w, err = OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	status, err = w.(*wallet).BatchStatus(ctx)
+	require.NoError(t, err)
+	require.True(t, status.Current())
+	require.Equal(t, "renamed", w.(*wallet).batch.entries[0].name)
+}
+
+// unavailableStore is a store that cannot retrieve wallets.
+type unavailableStore struct {
+	e2wtypes.Store
+}
+
+func (*unavailableStore) RetrieveWallet(_ string) ([]byte, error) {
+	return nil, errors.New("permission denied")
+}
+
+func TestCreateWalletStoreUnavailable(t *testing.T) {
+	ctx := context.Background()
+	store := &unavailableStore{Store: scratch.New()}
+
+	_, err := CreateWallet(ctx, "test wallet", store, keystorev4.New())
+	require.EqualError(t, err, "failed to check for existing wallet: permission denied")
+
+	_, err = OpenWallet(ctx, "test wallet", store, keystorev4.New())
+	require.EqualError(t, err, `failed to retrieve wallet "test wallet": permission denied`)
+	require.NotErrorIs(t, err, ErrWalletNotFound)
+
+	// No wallet should have been stored.
+	wallets := 0
+	for range store.RetrieveWallets() {
+		wallets++
+	}
+	require.Zero(t, wallets)
+}
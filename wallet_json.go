@@ -30,6 +30,9 @@ func (w *wallet) MarshalJSON() ([]byte, error) {
 	data["name"] = w.name
 	data["version"] = w.version
 	data["type"] = walletType
+	if w.crypto != nil {
+		data["crypto"] = w.crypto
+	}
 
 	return json.Marshal(data)
 }
@@ -97,6 +100,13 @@ func (w *wallet) UnmarshalJSON(data []byte) error {
 	} else {
 		return errors.New("wallet version missing")
 	}
+	if val, exists := v["crypto"]; exists {
+		crypto, ok := val.(map[string]any)
+		if !ok {
+			return errors.New("wallet crypto invalid")
+		}
+		w.crypto = crypto
+	}
 
 	return nil
 }
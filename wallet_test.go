@@ -98,3 +98,40 @@ func TestWalletUnlockLock(t *testing.T) {
 	require.NoError(t, err)
 	require.False(t, unlocked)
 }
+
+func TestWalletPassphrase(t *testing.T) {
+	ctx := context.Background()
+	store := scratch.New()
+	encryptor := keystorev4.New()
+	wallet, err := nd.CreateWallet(ctx, "test wallet", store, encryptor, nd.WithPassphrase([]byte("secret")))
+	require.NoError(t, err)
+
+	// Wrong passphrase.
+	require.EqualError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, nil), "incorrect passphrase")
+	require.EqualError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, []byte("wrong")), "incorrect passphrase")
+	unlocked, err := wallet.(e2wtypes.WalletLocker).IsUnlocked(ctx)
+	require.NoError(t, err)
+	require.False(t, unlocked)
+	_, err = wallet.(e2wtypes.WalletAccountCreator).CreateAccount(ctx, "account", []byte("test"))
+	require.EqualError(t, err, "wallet must be unlocked to create accounts")
+
+	// Right passphrase.
+	require.NoError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, []byte("secret")))
+	unlocked, err = wallet.(e2wtypes.WalletLocker).IsUnlocked(ctx)
+	require.NoError(t, err)
+	require.True(t, unlocked)
+
+	// Passphrase should persist.
+	wallet, err = nd.OpenWallet(ctx, "test wallet", store, encryptor)
+	require.NoError(t, err)
+	require.EqualError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, []byte("wrong")), "incorrect passphrase")
+	require.NoError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, []byte("secret")))
+
+	// Passphrase should survive export and import.
+	dump, err := wallet.(e2wtypes.WalletExporter).Export(ctx, []byte("dump"))
+	require.NoError(t, err)
+	wallet, err = nd.Import(ctx, dump, []byte("dump"), scratch.New(), encryptor)
+	require.NoError(t, err)
+	require.EqualError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, []byte("wrong")), "incorrect passphrase")
+	require.NoError(t, wallet.(e2wtypes.WalletLocker).Unlock(ctx, []byte("secret")))
+}